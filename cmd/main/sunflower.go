@@ -9,10 +9,34 @@
 package main
 
 import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sunflower"
 	"sunflower/internal/youling_http_server"
 )
 
 func main() {
+	// 使用 -tags embed 编译时，参数文件、模板与静态文件都嵌入在程序中。
+	// 开发时加上 -disk 参数优先读取磁盘上的文件，修改后不需要重新编译。
+	disk := flag.Bool("disk", false, "优先读取磁盘上的参数文件、模板与静态文件")
+	hash := flag.Bool("hash-password", false, "从标准输入读取密码，输出用户文件中使用的密码哈希")
+	flag.Parse()
+	if *hash {
+		password, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		h, err := youling_http_server.HashPassword(strings.TrimRight(password, "\r\n"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(h)
+		return
+	}
+	if sunflower.Files != nil {
+		youling_http_server.UseFS(sunflower.Files, *disk)
+	}
 	youling_http_server.CreateHttpServer()
 	return
 }
@@ -0,0 +1,27 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+//go:build embed
+
+package sunflower
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// 参数文件、模板与静态文件。证书等 config 下的其它文件不嵌入。
+//
+//go:embed config/*.toml templates static
+var embedded embed.FS
+
+// @brief 嵌入程序中的文件
+//  @remark 使用 go build -tags embed 编译时嵌入，部署时不需要再复制
+//  config、templates 与 static 目录。
+var Files fs.FS = embedded
@@ -0,0 +1,18 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+//go:build !embed
+
+package sunflower
+
+import "io/fs"
+
+// @brief 嵌入程序中的文件
+//  @remark 没有使用 -tags embed 编译时为 nil，所有文件都从磁盘读取。
+var Files fs.FS
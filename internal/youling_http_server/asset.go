@@ -0,0 +1,194 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 带指纹的静态文件内容不会改变，可以一直缓存
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// 指纹的长度（十六进制字符数）
+const fingerprintLen = 8
+
+// @brief 静态文件指纹表
+//  @remark 例如 /css/style.css 对应 /css/style.1a2b3c4d.css，
+//  指纹取文件内容的 sha256，文件内容改变后网址随之改变。
+type assets struct {
+	urls   map[string]string // 原网址 → 生成指纹表时带指纹的网址
+	files  map[string]string // 生成指纹表时带指纹的网址 → 原网址
+	mu     sync.Mutex
+	hashes map[string]fileHash // 原网址 → 最近一次计算的文件指纹
+}
+
+// @brief 计算指纹时的文件状态与指纹
+//  @remark 文件的修改时间与大小不变时不重新计算。
+type fileHash struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+// @brief 为设置了 fingerprint 的静态路由生成指纹表
+//  @param routing 路由列表
+//  @return 指纹表，失败时返回错误信息
+func buildAssets(routing []Router) (*assets, error) {
+	a := &assets{urls: make(map[string]string), files: make(map[string]string),
+		hashes: make(map[string]fileHash)}
+	for _, r := range routing {
+		if r.Type != "static" || !r.Fingerprint {
+			continue
+		}
+		root := path.Clean(filepath.ToSlash(r.Dir))
+		err := fs.WalkDir(siteFiles, r.Dir, func(file string, d fs.DirEntry,
+			err error) error {
+			if err != nil {
+				return err
+			}
+			// 预压缩文件随原文件一起返回，不需要单独的指纹
+			ext := path.Ext(file)
+			if d.IsDir() || ext == ".gz" || ext == ".br" {
+				return nil
+			}
+			f, err := siteFiles.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			fi, err := f.Stat()
+			if err != nil {
+				return err
+			}
+			hash, err := contentHash(f)
+			if err != nil {
+				return err
+			}
+			rel := strings.TrimPrefix(path.Clean(filepath.ToSlash(file)), root+"/")
+			url := path.Join(r.Path, rel)
+			hashed := fingerprintURL(url, hash)
+			a.urls[url] = hashed
+			a.files[hashed] = url
+			a.hashes[url] = fileHash{modTime: fi.ModTime(), size: fi.Size(), hash: hash}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.New("生成静态文件 " + r.Dir + " 的指纹时发生错误：" +
+				err.Error())
+		}
+	}
+	return a, nil
+}
+
+// @brief 计算文件内容的指纹
+//  @param r 文件内容
+func contentHash(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:fingerprintLen], nil
+}
+
+// @brief 在文件扩展名之前加上指纹
+//  @param url 原网址
+//  @param hash 指纹
+func fingerprintURL(url string, hash string) string {
+	ext := path.Ext(url)
+	return strings.TrimSuffix(url, ext) + "." + hash + ext
+}
+
+// @brief 去掉网址中的指纹
+//  @param url 可能带有指纹的网址
+//  @return 原网址，网址中没有指纹时返回 false
+//  @remark 用于旧页面引用了已经更新的文件，此时跳转到当前内容的网址。
+func stripFingerprint(url string) (string, bool) {
+	ext := path.Ext(url)
+	base := strings.TrimSuffix(url, ext)
+	i := strings.LastIndexByte(base, '.')
+	if i < 0 || len(base)-i-1 != fingerprintLen ||
+		strings.Contains(base[i:], "/") {
+		return "", false
+	}
+	if _, err := hex.DecodeString(base[i+1:]); err != nil {
+		return "", false
+	}
+	return base[:i] + ext, true
+}
+
+// @brief 把模板中引用的静态文件网址替换为带指纹的网址
+//  @param templates 模板文件内容
+//  @remark 只替换写在引号中的绝对路径，例如 href="/css/style.css"。
+func (a *assets) rewrite(templates map[string]string) {
+	if len(a.urls) == 0 {
+		return
+	}
+	pairs := make([]string, 0, len(a.urls)*4)
+	for url, hashed := range a.urls {
+		pairs = append(pairs, `"`+url+`"`, `"`+hashed+`"`, "'"+url+"'",
+			"'"+hashed+"'")
+	}
+	replacer := strings.NewReplacer(pairs...)
+	for name, content := range templates {
+		templates[name] = replacer.Replace(content)
+	}
+}
+
+// @brief 查找带指纹的网址对应的原网址
+//  @param url 请求的网址
+//  @return 原网址与网址是否带有指纹，不是带指纹的网址时返回 url 本身
+//  @remark 文件在生成指纹表之后可能已经改变，指纹是否与内容一致由 current 检查。
+func (a *assets) lookup(url string) (string, bool) {
+	if orig, ok := a.files[url]; ok {
+		return orig, true
+	}
+	if orig, ok := stripFingerprint(url); ok {
+		if _, ok := a.urls[orig]; ok {
+			return orig, true
+		}
+	}
+	return url, false
+}
+
+// @brief 文件当前内容对应的带指纹的网址
+//  @param url 原网址
+//  @param f 已打开的文件，计算指纹后回到开头
+//  @param fi 文件信息
+//  @return 带指纹的网址，失败时返回错误信息
+func (a *assets) current(url string, f io.ReadSeeker, fi fs.FileInfo) (string, error) {
+	a.mu.Lock()
+	h, ok := a.hashes[url]
+	a.mu.Unlock()
+	if ok && h.modTime.Equal(fi.ModTime()) && h.size == fi.Size() {
+		return fingerprintURL(url, h.hash), nil
+	}
+	hash, err := contentHash(f)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	a.mu.Lock()
+	if a.hashes == nil {
+		a.hashes = make(map[string]fileHash)
+	}
+	a.hashes[url] = fileHash{modTime: fi.ModTime(), size: fi.Size(), hash: hash}
+	a.mu.Unlock()
+	return fingerprintURL(url, hash), nil
+}
@@ -0,0 +1,131 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试 stripFingerprint 函数
+func TestStripFingerprint(t *testing.T) {
+	cases := []struct {
+		url  string
+		want string
+		ok   bool
+	}{
+		{"/css/style.1a2b3c4d.css", "/css/style.css", true},
+		{"/js/app.min.0123abcd.js", "/js/app.min.js", true},
+		{"/css/style.css", "", false},
+		{"/css/style.zzzzzzzz.css", "", false},
+		{"/css/style.1a2b3c.css", "", false},
+	}
+	for _, c := range cases {
+		got, ok := stripFingerprint(c.url)
+		if got != c.want || ok != c.ok {
+			t.Errorf("%s 得到 %q %v，预期 %q %v", c.url, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+// @brief 测试带指纹的静态文件
+func TestAssets(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	config := testConfig()
+	config.Routing = "testdata/routing_assets.toml"
+	config.TemplatesList = "testdata/tpl_assets.toml"
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	// 1. 模板中的引用替换为带指纹的网址，不存在的文件不替换
+	page := get("/").Body.String()
+	hashed := regexp.MustCompile(`/static/style\.[0-9a-f]{8}\.css`).FindString(page)
+	if hashed == "" || !strings.Contains(page, `'/static/none.js'`) {
+		t.Fatalf("模板中的引用没有正确替换：%s", page)
+	}
+	t.Run("带指纹的网址一直缓存", func(t *testing.T) {
+		w := get(hashed)
+		if w.Code != http.StatusOK || w.Body.String() != "body { color: red; }\n" ||
+			w.Header().Get("Cache-Control") != immutableCacheControl {
+			t.Errorf("响应不正确：%d %v", w.Code, w.Header())
+		}
+	})
+	t.Run("原网址使用路由的 cache_control", func(t *testing.T) {
+		w := get("/static/style.css")
+		if w.Code != http.StatusOK ||
+			w.Header().Get("Cache-Control") != "public, max-age=60" {
+			t.Errorf("响应不正确：%d %v", w.Code, w.Header())
+		}
+	})
+	t.Run("指纹已过期时跳转到当前的网址", func(t *testing.T) {
+		w := get("/static/style.00000000.css")
+		if w.Code != http.StatusFound || w.Header().Get("Location") != hashed ||
+			w.Header().Get("Cache-Control") == immutableCacheControl {
+			t.Errorf("响应不正确：%d %v", w.Code, w.Header())
+		}
+	})
+}
+
+// @brief 测试生成指纹表之后静态文件改变
+func TestAssetChanged(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.js")
+	if err := os.WriteFile(file, []byte("var a = 1;\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r := Router{Type: "static", Path: "/js", Dir: dir, Fingerprint: true}
+	a, err := buildAssets([]Router{r})
+	if err != nil {
+		t.Fatalf("生成指纹表失败：%v", err)
+	}
+	router := gin.New()
+	router.GET("/js/*filepath", serveStatic(r, &site{assets: a}))
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	old := a.urls["/js/app.js"]
+	if w := get(old); w.Code != http.StatusOK ||
+		w.Header().Get("Cache-Control") != immutableCacheControl {
+		t.Fatalf("响应不正确：%d %v", w.Code, w.Header())
+	}
+	// 文件在重新载入之前改变
+	if err := os.WriteFile(file, []byte("var a = 2;\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(file, later, later)
+	w := get(old)
+	current := w.Header().Get("Location")
+	if w.Code != http.StatusFound || current == old ||
+		w.Header().Get("Cache-Control") == immutableCacheControl {
+		t.Fatalf("旧指纹的响应不正确：%d %v", w.Code, w.Header())
+	}
+	w = get(current)
+	if w.Code != http.StatusOK || w.Body.String() != "var a = 2;\n" ||
+		w.Header().Get("Cache-Control") != immutableCacheControl {
+		t.Errorf("新指纹的响应不正确：%d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+}
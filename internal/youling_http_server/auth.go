@@ -0,0 +1,140 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 路由的访问控制类型
+const (
+	authAnonymous = "anonymous" // 所有人都可以访问
+	authUser      = "user"      // 登录后才能访问
+)
+
+// @brief 检查路由的访问控制参数
+//  @param r 路由
+//  @return 参数有效时返回 nil
+func checkAuth(r Router) error {
+	switch r.Auth {
+	case "", authAnonymous:
+		if len(r.Roles) > 0 {
+			return errors.New("路由 " + r.Path + " 设置了 roles，auth 不能为 anonymous。")
+		}
+	case authUser:
+	default:
+		return errors.New("路由 " + r.Path + " 的 auth 无效：" + r.Auth)
+	}
+	return nil
+}
+
+// @brief 访问控制中间件
+//  @param s http 服务，用于读取用户的角色
+//  @param st 站点内容，用于查找登录页面
+//  @param r 路由，auth 为 user 时需要登录，roles 不为空时还需要具有其中一个角色
+//  @remark 通过会话登录与使用 API 令牌的请求使用同样的检查。
+//  没有登录时，浏览器打开页面跳转到登录页面，其它请求返回 401；
+//  已登录但没有所需角色，或令牌的权限范围不包括此路由时返回 403。
+//  用户已从用户存储中删除时按没有登录处理，同时结束会话，令牌也不再有效。
+func authorize(s *Server, st *site, r Router) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if r.Auth != authUser {
+			c.Next()
+			return
+		}
+		name := c.GetString(ctxUser)
+		if name == "" {
+			unauthenticated(c, st)
+			return
+		}
+		u, err := s.users.Get(name)
+		if err == errUserNotFound {
+			appLog().Info("用户已不存在", "request_id", c.GetString(ctxRequestID),
+				"user", name)
+			if _, ok := c.Get(ctxToken); ok {
+				c.Header("WWW-Authenticate",
+					`Bearer realm="sunflower", error="invalid_token"`)
+				abortWithError(c, http.StatusUnauthorized)
+				return
+			}
+			s.sessions.end(c)
+			unauthenticated(c, st)
+			return
+		}
+		if err != nil {
+			appLog().Error("读取用户失败", "request_id", c.GetString(ctxRequestID),
+				"user", name, "error", err)
+			abortWithError(c, http.StatusInternalServerError)
+			return
+		}
+		if t, ok := c.Get(ctxToken); ok && !t.(APIToken).allows(r) {
+			abortWithError(c, http.StatusForbidden)
+			return
+		}
+		if len(r.Roles) > 0 && !hasRole(u.Roles, r.Roles) {
+			abortWithError(c, http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// @brief 拒绝没有登录的请求
+//  @param c 上下文
+//  @param st 站点内容，用于查找登录页面
+//  @remark 浏览器打开页面时跳转到登录页面，其它请求返回 401。
+func unauthenticated(c *gin.Context, st *site) {
+	if login := st.loginPath(); login != "" && wantsHTML(c) {
+		c.Abort()
+		c.Redirect(http.StatusSeeOther, login+"?next="+
+			url.QueryEscape(c.Request.URL.RequestURI()))
+		return
+	}
+	c.Header("WWW-Authenticate", `Bearer realm="sunflower"`)
+	abortWithError(c, http.StatusUnauthorized)
+}
+
+// @brief 是否具有所需角色中的一个
+//  @param roles 用户的角色
+//  @param required 所需的角色
+func hasRole(roles []string, required []string) bool {
+	for _, r := range roles {
+		for _, q := range required {
+			if r == q {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// @brief 请求是否来自浏览器打开的页面
+//  @remark 只有 GET 与 HEAD 请求，且 Accept 中明确包含 text/html 时才视为浏览器。
+func wantsHTML(c *gin.Context) bool {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		return false
+	}
+	return strings.Contains(c.GetHeader("Accept"), gin.MIMEHTML)
+}
+
+// @brief 登录页面的路径
+//  @return 路由表中 function 为 login 的模板路由的路径，没有时返回空字符串
+func (st *site) loginPath() string {
+	for _, r := range st.routers.Routing {
+		if r.Type == "template" && r.Function == "login" {
+			return r.Path
+		}
+	}
+	return ""
+}
@@ -0,0 +1,121 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 登录并返回会话 Cookie
+func loginCookie(t *testing.T, s *Server, name string) string {
+	form := url.Values{"username": {name}, "password": {"secret"}}
+	req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	addCSRF(req)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("%s 登录失败：%d", name, w.Code)
+	}
+	return strings.SplitN(w.Header().Get("Set-Cookie"), ";", 2)[0]
+}
+
+// @brief 测试路由的访问控制
+func TestAuthorize(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	config := testConfig()
+	config.ServerConfig = "testdata/server_users.toml"
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	alice := loginCookie(t, s, "alice")
+	bob := loginCookie(t, s, "bob")
+	cases := []struct {
+		name   string
+		path   string
+		accept string
+		cookie string
+		code   int
+	}{
+		{"公开页面", "/", "text/html", "", http.StatusOK},
+		{"浏览器跳转到登录页面", "/private", "text/html", "", http.StatusSeeOther},
+		{"其它客户端返回 401", "/private", "application/json", "", http.StatusUnauthorized},
+		{"登录后可以访问", "/private", "text/html", bob, http.StatusOK},
+		{"没有所需角色时返回 403", "/admin", "text/html", bob, http.StatusForbidden},
+		{"具有所需角色", "/admin", "text/html", alice, http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", c.path, nil)
+		req.Header.Set("Accept", c.accept)
+		if c.cookie != "" {
+			req.Header.Set("Cookie", c.cookie)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		if w.Code != c.code {
+			t.Errorf("%s：%s 返回 %d，预期 %d", c.name, c.path, w.Code, c.code)
+		}
+		if c.code == http.StatusSeeOther &&
+			w.Header().Get("Location") != "/login?next=%2Fprivate" {
+			t.Errorf("%s：跳转地址不正确：%s", c.name, w.Header().Get("Location"))
+		}
+	}
+	t.Run("错误测试：用户已被删除", func(t *testing.T) {
+		now := time.Now()
+		id := newSessionID()
+		s.sessions.store.Put(Session{ID: id, User: "ghost", Created: now, LastSeen: now})
+		ghost := s.sessions.conf.CookieName + "=" + id + "." + s.sessions.sign(id)
+		_, token, err := createToken(s.tokens, "ghost", "脚本", nil, 0)
+		if err != nil {
+			t.Fatalf("创建令牌失败：%v", err)
+		}
+		get := func(path string, header string, value string) int {
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("Accept", "application/json")
+			req.Header.Set(header, value)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, req)
+			return w.Code
+		}
+		for _, path := range []string{"/private", "/admin"} {
+			if code := get(path, "Cookie", ghost); code != http.StatusUnauthorized {
+				t.Errorf("会话：%s 返回 %d，预期 401", path, code)
+			}
+			if code := get(path, "Authorization", "Bearer "+token); code != http.StatusUnauthorized {
+				t.Errorf("令牌：%s 返回 %d，预期 401", path, code)
+			}
+		}
+		if _, err := s.sessions.store.Get(id); err != errSessionNotFound {
+			t.Errorf("用户已被删除，但会话没有结束：%v", err)
+		}
+	})
+	t.Run("登录页面带有 next", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/login?next=%2Fprivate", nil))
+		if !strings.Contains(w.Body.String(), `value="/private"`) {
+			t.Errorf("登录页面中没有 next：%s", w.Body.String())
+		}
+	})
+	t.Run("错误测试：anonymous 不能设置 roles", func(t *testing.T) {
+		if checkAuth(Router{Path: "/", Roles: []string{"admin"}}) == nil {
+			t.Errorf("anonymous 设置了 roles，但没有报错。")
+		}
+		if checkAuth(Router{Path: "/", Auth: "admin"}) == nil {
+			t.Errorf("auth 无效，但没有报错。")
+		}
+	})
+}
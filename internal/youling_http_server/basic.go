@@ -12,12 +12,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
 	"strings"
 	"sunflower/pkg/youling_string"
 	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/pelletier/go-toml"
 )
 
@@ -45,7 +43,7 @@ type PHs struct {
 //  @return 成功：nil，失败：错误信息
 func readTemplates(f string, tpl map[string]string) error {
 	// 读取模板列表配置文件
-	conf, err := toml.LoadFile(f)
+	conf, err := loadToml(f)
 	if err != nil {
 		return errors.New("载入模板配置文件 " + f + " 失败。")
 	}
@@ -68,7 +66,7 @@ func readTemplates(f string, tpl map[string]string) error {
 	}
 	// 把模板列表中的名称与实际的文件内容逐一读入哈希表中
 	for _, t := range tp.Templates {
-		contents, err := os.ReadFile(t.File)
+		contents, err := readFile(t.File)
 		if err != nil {
 			return errors.New("读取模板文件 " + t.File + " 失败。")
 		}
@@ -83,7 +81,7 @@ func readTemplates(f string, tpl map[string]string) error {
 //  @return 成功：nil，失败：错误信息
 func readPlaceHolderList(file string, ph map[string][]string) error {
 	// 读取占位符列表配置文件
-	conf, err := toml.LoadFile(file)
+	conf, err := loadToml(file)
 	if err != nil {
 		return errors.New("载入占位符文件 " + file + " 时发生错误：")
 	}
@@ -132,9 +130,9 @@ func replacePlaceHolder(r Router, placeHolder []string,
 // @brief 设置 http server 参数
 //  @param srv http服务器
 //  @return 成功：nil，失败：错误信息
-func setServer(file string, srv *http.Server, r *gin.Engine) error {
+func setServer(file string, srv *http.Server, r http.Handler) error {
 	// 从TOML配置文件中读取http服务器参数
-	config, err := toml.LoadFile(file)
+	config, err := loadToml(file)
 	if err != nil {
 		return errors.New("载入服务器参数文件 server_config.toml 时发生错误。")
 	}
@@ -148,3 +146,39 @@ func setServer(file string, srv *http.Server, r *gin.Engine) error {
 		time.Second
 	return nil
 }
+
+// 关闭服务时等待未完成请求的默认时间
+const defaultShutdownTimeout = 5 * time.Second
+
+// @brief 读取关闭服务时等待未完成请求的时间
+//  @param file 服务器参数文件
+//  @return 等待时间，读取失败时返回默认值与错误信息
+func readShutdownTimeout(file string) (time.Duration, error) {
+	config, err := loadToml(file)
+	if err != nil {
+		return defaultShutdownTimeout,
+			errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	timeout, ok := config.GetDefault("server.ShutdownTimeout",
+		int64(defaultShutdownTimeout/time.Second)).(int64)
+	if !ok || timeout <= 0 {
+		return defaultShutdownTimeout,
+			errors.New("服务器参数文件 " + file + " 中 ShutdownTimeout 字段无效。")
+	}
+	return time.Duration(timeout) * time.Second, nil
+}
+
+// @brief 读取就绪检查失败后到停止接受请求之间的等待时间
+//  @param file 服务器参数文件
+//  @return 等待时间，读取失败时返回 0 与错误信息
+func readShutdownDelay(file string) (time.Duration, error) {
+	config, err := loadToml(file)
+	if err != nil {
+		return 0, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	delay, ok := config.GetDefault("server.ShutdownDelay", int64(0)).(int64)
+	if !ok || delay < 0 {
+		return 0, errors.New("服务器参数文件 " + file + " 中 ShutdownDelay 字段无效。")
+	}
+	return time.Duration(delay) * time.Second, nil
+}
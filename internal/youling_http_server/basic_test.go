@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pelletier/go-toml"
@@ -196,12 +197,11 @@ func TestReadPlaceHolderList(t *testing.T) {
 func TestReplacePlaceHolder(t *testing.T) {
 	// 定义输入参数
 	r := Router{
-		"template",
-		"/",
-		"homepage",
-		"general1",
-		"replacement",
-		"",
+		Type:        "template",
+		Path:        "/",
+		Function:    "homepage",
+		Template:    "general1",
+		Replacement: "replacement",
 	}
 	placeHolder := []string{"subdir", "funcmenu"}
 	template, error := os.ReadFile("testdata/general1.html")
@@ -244,3 +244,25 @@ func TestSetServer(t *testing.T) {
 		}
 	})
 }
+
+// @brief 测试 readShutdownTimeout 函数
+func TestReadShutdownTimeout(t *testing.T) {
+	t.Run("正常测试", func(t *testing.T) {
+		timeout, err := readShutdownTimeout("testdata/server_config.toml")
+		if err != nil {
+			t.Errorf("读取等待时间失败：%v", err)
+		}
+		if timeout != 10*time.Second {
+			t.Errorf("等待时间不正确：%v", timeout)
+		}
+	})
+	t.Run("错误测试：参数文件名错误", func(t *testing.T) {
+		timeout, err := readShutdownTimeout("testdata/server_config_error.toml")
+		if err == nil {
+			t.Errorf("参数文件名不正确，但没有报错。")
+		}
+		if timeout != defaultShutdownTimeout {
+			t.Errorf("读取失败时没有使用默认等待时间。")
+		}
+	})
+}
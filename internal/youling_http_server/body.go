@@ -0,0 +1,129 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 读取的请求内容超过路由的 max_body
+var errBodyTooLarge = errors.New("请求内容过大。")
+
+// 解析 multipart 表单时保存在内存中的最大字节数，与 gin 的默认值相同
+const maxFormMemory = 32 << 20
+
+// @brief 检查路由的请求内容参数
+//  @param r 路由
+//  @return 参数有效时返回 nil
+func checkBody(r Router) error {
+	if r.MaxBody < 0 {
+		return errors.New("路由 " + r.Path + " 的 max_body 无效：" +
+			strconv.FormatInt(r.MaxBody, 10))
+	}
+	for _, t := range r.ContentTypes {
+		if _, _, err := mime.ParseMediaType(t); err != nil {
+			return errors.New("路由 " + r.Path + " 的 content_types 无效：" + t)
+		}
+	}
+	return nil
+}
+
+// @brief 限制读取大小的请求内容
+//  @remark 与 http.MaxBytesReader 相同，但超过限制时返回 errBodyTooLarge，
+//  便于处理函数返回 413。
+type limitedBody struct {
+	io.ReadCloser
+	remain int64 // 还可以读取的字节数
+}
+
+// @brief 读取请求内容
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remain < 0 {
+		return 0, errBodyTooLarge
+	}
+	// 多读一个字节，用于判断是否超过限制
+	if int64(len(p)) > b.remain+1 {
+		p = p[:b.remain+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remain {
+		n = int(b.remain)
+		b.remain = -1
+		return n, errBodyTooLarge
+	}
+	b.remain -= int64(n)
+	return n, err
+}
+
+// @brief 请求是否带有内容
+func hasBody(req *http.Request) bool {
+	return req.ContentLength != 0 && req.Body != nil && req.Body != http.NoBody
+}
+
+// @brief 请求内容中间件
+//  @param r 路由，max_body 为允许的最大字节数，content_types 为接受的 Content-Type
+//  @remark 请求内容超过 max_body 时返回 413，Content-Type 不在 content_types 中时返回 415。
+//  没有 Content-Length 的请求在读取时检查大小，处理函数读到 errBodyTooLarge 时返回 413。
+//  表单在这里解析，因为 gin 的 PostForm 会忽略读取错误，处理函数无法知道内容过大。
+func checkRequestBody(r Router) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasBody(c.Request) {
+			c.Next()
+			return
+		}
+		if len(r.ContentTypes) > 0 && !containsFold(r.ContentTypes, c.ContentType()) {
+			appLog().Info("不接受的 Content-Type", "request_id", c.GetString(ctxRequestID),
+				"path", c.Request.URL.Path, "content_type", c.ContentType())
+			abortWithError(c, http.StatusUnsupportedMediaType)
+			return
+		}
+		if r.MaxBody > 0 {
+			if c.Request.ContentLength > r.MaxBody {
+				abortWithError(c, http.StatusRequestEntityTooLarge)
+				return
+			}
+			c.Request.Body = &limitedBody{ReadCloser: c.Request.Body, remain: r.MaxBody}
+			if err := parseForm(c.Request, c.ContentType()); errors.Is(err, errBodyTooLarge) {
+				abortWithError(c, http.StatusRequestEntityTooLarge)
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// @brief 解析表单
+//  @param req 请求
+//  @param contentType 请求的 Content-Type
+//  @return 解析时发生的错误，不是表单时返回 nil
+//  @remark 解析结果保存在请求中，之后的 PostForm 不再读取请求内容。
+func parseForm(req *http.Request, contentType string) error {
+	switch contentType {
+	case "application/x-www-form-urlencoded":
+		return req.ParseForm()
+	case "multipart/form-data":
+		return req.ParseMultipartForm(maxFormMemory)
+	}
+	return nil
+}
+
+// @brief 读取请求内容时出错的状态码
+//  @param err 错误信息
+func bodyErrorStatus(err error) int {
+	if errors.Is(err, errBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,104 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试限制读取大小的请求内容
+func TestLimitedBody(t *testing.T) {
+	read := func(body string, limit int64) (string, error) {
+		b := &limitedBody{ReadCloser: io.NopCloser(strings.NewReader(body)),
+			remain: limit}
+		data, err := ioutil.ReadAll(b)
+		return string(data), err
+	}
+	if data, err := read("1234", 4); err != nil || data != "1234" {
+		t.Errorf("内容没有超过限制，但读取失败：%q %v", data, err)
+	}
+	if _, err := read("12345", 4); err != errBodyTooLarge {
+		t.Errorf("内容超过限制，但返回 %v", err)
+	}
+}
+
+// @brief 测试路由的请求内容限制
+func TestRequestBody(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	s, err := New(testConfig())
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	post := func(body string, contentType string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/submit-data", strings.NewReader(body))
+		if chunked {
+			// 没有 Content-Length，只能在读取时检查大小
+			req.ContentLength = -1
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		addCSRF(req)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w
+	}
+	large := `{"task_name":"` + strings.Repeat("a", 64) + `"}`
+	cases := []struct {
+		name        string
+		body        string
+		contentType string
+		chunked     bool
+		code        int
+	}{
+		{"正常提交", `{"task_number":"1"}`, "application/json", false, http.StatusCreated},
+		{"Content-Type 带有参数", `{}`, "application/json; charset=utf-8", false,
+			http.StatusCreated},
+		{"错误测试：Content-Type 不正确", `{}`, "application/x-www-form-urlencoded", false,
+			http.StatusUnsupportedMediaType},
+		{"错误测试：没有 Content-Type", `{}`, "", false, http.StatusUnsupportedMediaType},
+		{"错误测试：内容过大", large, "application/json", false,
+			http.StatusRequestEntityTooLarge},
+		{"错误测试：没有 Content-Length 且内容过大", large, "application/json", true,
+			http.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		if w := post(c.body, c.contentType, c.chunked); w.Code != c.code {
+			t.Errorf("%s：返回 %d，预期 %d", c.name, w.Code, c.code)
+		}
+	}
+	t.Run("错误测试：没有 Content-Length 且表单过大", func(t *testing.T) {
+		form := url.Values{"username": {"alice"}, "password": {strings.Repeat("a", 256)}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.ContentLength = -1
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		addCSRF(req)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("返回 %d，预期 413", w.Code)
+		}
+	})
+	t.Run("错误测试：参数无效", func(t *testing.T) {
+		if checkBody(Router{Path: "/", MaxBody: -1}) == nil {
+			t.Errorf("max_body 无效，但没有报错。")
+		}
+		if checkBody(Router{Path: "/", ContentTypes: []string{"json;;"}}) == nil {
+			t.Errorf("content_types 无效，但没有报错。")
+		}
+	})
+}
@@ -0,0 +1,326 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml"
+)
+
+// @brief 压缩参数
+type compressConfig struct {
+	Enabled     bool     `toml:"enabled"`      // 是否压缩响应
+	MinSize     int      `toml:"min_size"`     // 响应小于此大小（字节）时不压缩
+	Types       []string `toml:"types"`        // 需要压缩的 Content-Type
+	GzipLevel   int      `toml:"gzip_level"`   // gzip 压缩级别，1～9
+	BrotliLevel int      `toml:"brotli_level"` // brotli 压缩级别，0～11
+}
+
+// 默认需要压缩的 Content-Type
+var defaultCompressTypes = []string{"text/html", "text/css", "text/plain",
+	"application/javascript", "text/javascript", "application/json",
+	"image/svg+xml"}
+
+// @brief 读取压缩参数
+//  @param file 服务器参数文件
+//  @return 压缩参数，失败时返回错误信息
+func readCompress(file string) (compressConfig, error) {
+	conf := compressConfig{Enabled: false, MinSize: 1024,
+		Types: defaultCompressTypes, GzipLevel: gzip.DefaultCompression,
+		BrotliLevel: 5}
+	config, err := loadToml(file)
+	if err != nil {
+		return conf, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	if !config.Has("compression") {
+		return conf, nil
+	}
+	if err := config.Get("compression").(*toml.Tree).Unmarshal(&conf); err != nil {
+		return conf, errors.New("解析服务器参数文件 " + file + " 中的 compression 时发生错误：" +
+			err.Error())
+	}
+	if conf.GzipLevel < gzip.HuffmanOnly || conf.GzipLevel > gzip.BestCompression {
+		return conf, errors.New("服务器参数文件 " + file + " 中 gzip_level 字段无效。")
+	}
+	if conf.BrotliLevel < brotli.BestSpeed ||
+		conf.BrotliLevel > brotli.BestCompression {
+		return conf, errors.New("服务器参数文件 " + file + " 中 brotli_level 字段无效。")
+	}
+	return conf, nil
+}
+
+// @brief 从 Accept-Encoding 中选出压缩方式
+//  @param header Accept-Encoding 请求头
+//  @param encodings 可以使用的压缩方式，按优先顺序排列
+//  @return 选中的压缩方式，都不接受时返回空字符串
+func acceptEncoding(header string, encodings ...string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			q, _ = strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	for _, e := range encodings {
+		if ok, found := accepted[e]; found {
+			if ok {
+				return e
+			}
+			continue
+		}
+		if accepted["*"] {
+			return e
+		}
+	}
+	return ""
+}
+
+// @brief 响应压缩中间件
+//  @param conf 压缩参数
+//  @remark 客户端接受时优先使用 brotli，其次 gzip。
+//  响应内容达到最小大小且 Content-Type 在列表中时才压缩。
+func compress(conf compressConfig) gin.HandlerFunc {
+	gzipPool := sync.Pool{New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, conf.GzipLevel)
+		return w
+	}}
+	brotliPool := sync.Pool{New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, conf.BrotliLevel)
+	}}
+	types := map[string]bool{}
+	for _, t := range conf.Types {
+		types[t] = true
+	}
+	return func(c *gin.Context) {
+		if !conf.Enabled || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		c.Header("Vary", "Accept-Encoding")
+		encoding := acceptEncoding(c.GetHeader("Accept-Encoding"), "br", "gzip")
+		if encoding == "" {
+			c.Next()
+			return
+		}
+		cw := &compressWriter{ResponseWriter: c.Writer, encoding: encoding,
+			minSize: conf.MinSize, types: types}
+		finished := false
+		defer func() {
+			c.Writer = cw.ResponseWriter
+			if finished {
+				cw.finish()
+			} else {
+				// 发生 panic 时丢弃缓存的内容，由 recovery 直接写出不压缩的错误页面。
+				// 不在这里 recover，让崩溃报告保留原来的调用栈
+				cw.discard()
+			}
+			switch e := cw.encoder.(type) {
+			case *gzip.Writer:
+				gzipPool.Put(e)
+			case *brotli.Writer:
+				brotliPool.Put(e)
+			}
+		}()
+		cw.newEncoder = func(w io.Writer) io.WriteCloser {
+			if encoding == "br" {
+				e := brotliPool.Get().(*brotli.Writer)
+				e.Reset(w)
+				return e
+			}
+			e := gzipPool.Get().(*gzip.Writer)
+			e.Reset(w)
+			return e
+		}
+		c.Writer = cw
+		c.Next()
+		finished = true
+	}
+}
+
+// @brief 压缩响应内容的 ResponseWriter
+//  @remark 先缓存响应内容，达到最小大小或处理结束时再决定是否压缩。
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding   string
+	minSize    int
+	types      map[string]bool
+	newEncoder func(io.Writer) io.WriteCloser
+	encoder    io.WriteCloser // 决定压缩后使用的压缩器
+	buf        []byte         // 决定是否压缩之前缓存的内容
+	decided    bool           // 是否已决定压缩与否
+}
+
+// @brief 决定是否压缩，并写出已缓存的内容
+//  @param final 是否已处理结束
+func (cw *compressWriter) decide(final bool) error {
+	if cw.decided {
+		return nil
+	}
+	cw.decided = true
+	h := cw.Header()
+	ctype, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	status := cw.Status()
+	if !(final && len(cw.buf) < cw.minSize) && cw.types[ctype] &&
+		h.Get("Content-Encoding") == "" && status != http.StatusNoContent &&
+		status != http.StatusNotModified && status != http.StatusPartialContent {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		cw.encoder = cw.newEncoder(cw.ResponseWriter)
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := cw.write(buf)
+	return err
+}
+
+// @brief 写出内容，已决定压缩时经过压缩器
+func (cw *compressWriter) write(p []byte) (int, error) {
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.decided {
+		return cw.write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.decide(false); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (cw *compressWriter) WriteString(s string) (int, error) {
+	return cw.Write([]byte(s))
+}
+
+// @brief 缓存中有内容时也视为已写出，避免错误页面追加在已有内容之后
+func (cw *compressWriter) Written() bool {
+	return len(cw.buf) > 0 || cw.ResponseWriter.Written()
+}
+
+func (cw *compressWriter) WriteHeaderNow() {
+	// 只写响应头、不写内容时不压缩
+	if !cw.decided && len(cw.buf) == 0 {
+		cw.decided = true
+	}
+	cw.ResponseWriter.WriteHeaderNow()
+}
+
+func (cw *compressWriter) Flush() {
+	cw.decide(false)
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	cw.ResponseWriter.Flush()
+}
+
+// @brief 处理结束，写出缓存的内容并关闭压缩器
+func (cw *compressWriter) finish() {
+	cw.decide(true)
+	if cw.encoder != nil {
+		cw.encoder.Close()
+	}
+}
+
+// @brief 处理中断，丢弃缓存的内容，不写出任何内容
+//  @remark 还没有写出内容时同时删除 Content-Encoding，错误页面不经过压缩。
+func (cw *compressWriter) discard() {
+	cw.buf = nil
+	cw.decided = true
+	if !cw.ResponseWriter.Written() {
+		cw.Header().Del("Content-Encoding")
+	}
+}
+
+// 预压缩文件的扩展名
+var precompressedExt = map[string]string{"br": ".br", "gzip": ".gz"}
+
+// @brief 静态文件处理函数
+//  @param r 静态路由
+//  @param st 站点内容，用于查找带指纹的网址
+//  @remark 不列出目录内容。客户端接受压缩且存在同名的 .br 或 .gz 文件时，
+//  直接返回预压缩的文件。指纹与文件当前内容一致时一直缓存，不一致时（文件已经改变）
+//  跳转到当前内容的网址，其它网址使用路由的 cache_control。
+func serveStatic(r Router, st *site) gin.HandlerFunc {
+	root := staticDir(r.Dir)
+	return func(c *gin.Context) {
+		name := path.Clean("/" + c.Param("filepath"))
+		// 带指纹的网址换回原文件名
+		requested := path.Join(r.Path, name)
+		a := st.currentAssets()
+		url, fingerprinted := a.lookup(requested)
+		name = strings.TrimPrefix(url, strings.TrimSuffix(r.Path, "/"))
+		f, err := root.Open(name)
+		if err != nil {
+			abortWithError(c, http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() {
+			abortWithError(c, http.StatusNotFound)
+			return
+		}
+		cacheControl := r.CacheControl
+		if fingerprinted {
+			current, err := a.current(url, f, fi)
+			if err != nil {
+				abortWithError(c, http.StatusInternalServerError)
+				return
+			}
+			if current != requested {
+				c.Redirect(http.StatusFound, current)
+				return
+			}
+			cacheControl = immutableCacheControl
+		}
+		if cacheControl != "" {
+			c.Header("Cache-Control", cacheControl)
+		}
+		c.Header("Vary", "Accept-Encoding")
+		encoding := acceptEncoding(c.GetHeader("Accept-Encoding"), "br", "gzip")
+		if encoding != "" {
+			if pf, err := root.Open(name + precompressedExt[encoding]); err == nil {
+				defer pf.Close()
+				if pfi, err := pf.Stat(); err == nil && !pfi.IsDir() {
+					ctype := mime.TypeByExtension(path.Ext(name))
+					if ctype == "" {
+						ctype = "application/octet-stream"
+					}
+					c.Header("Content-Type", ctype)
+					c.Header("Content-Encoding", encoding)
+					http.ServeContent(c.Writer, c.Request, name, fi.ModTime(), pf)
+					return
+				}
+			}
+		}
+		http.ServeContent(c.Writer, c.Request, name, fi.ModTime(), f)
+	}
+}
@@ -0,0 +1,208 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试 acceptEncoding 函数
+func TestAcceptEncoding(t *testing.T) {
+	cases := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"br;q=0, gzip", "gzip"},
+		{"*", "br"},
+		{"*, br;q=0", "gzip"},
+		{"identity", ""},
+	}
+	for _, c := range cases {
+		if got := acceptEncoding(c.header, "br", "gzip"); got != c.want {
+			t.Errorf("Accept-Encoding: %q 选出 %q，预期 %q", c.header, got, c.want)
+		}
+	}
+}
+
+// @brief 测试 readCompress 函数
+func TestReadCompress(t *testing.T) {
+	conf, err := readCompress("testdata/server_local.toml")
+	if err != nil || conf.Enabled || conf.MinSize != 1024 {
+		t.Errorf("默认压缩参数不正确：%+v %v", conf, err)
+	}
+	conf, err = readCompress("testdata/server_compress.toml")
+	if err != nil || !conf.Enabled || conf.MinSize != 64 || conf.GzipLevel != 6 ||
+		conf.BrotliLevel != 4 || len(conf.Types) == 0 {
+		t.Errorf("压缩参数不正确：%+v %v", conf, err)
+	}
+}
+
+// @brief 测试响应压缩中间件
+func TestCompress(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	conf, err := readCompress("testdata/server_compress.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := strings.Repeat("<p>向日葵</p>", 100)
+	router := gin.New()
+	router.Use(compress(conf))
+	router.GET("/page", func(c *gin.Context) {
+		c.Data(200, "text/html; charset=utf-8", []byte(page))
+	})
+	router.GET("/small", func(c *gin.Context) {
+		c.Data(200, "text/html; charset=utf-8", []byte("<p>ok</p>"))
+	})
+	router.GET("/png", func(c *gin.Context) {
+		c.Data(200, "image/png", []byte(page))
+	})
+	get := func(path string, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	t.Run("gzip", func(t *testing.T) {
+		w := get("/page", "gzip")
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("没有使用 gzip 压缩：%v", w.Header())
+		}
+		r, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(r)
+		if string(body) != page {
+			t.Errorf("解压后的内容不正确：%s", body)
+		}
+	})
+	t.Run("brotli", func(t *testing.T) {
+		w := get("/page", "gzip, br")
+		if w.Header().Get("Content-Encoding") != "br" {
+			t.Fatalf("没有使用 brotli 压缩：%v", w.Header())
+		}
+		body, _ := io.ReadAll(brotli.NewReader(w.Body))
+		if string(body) != page {
+			t.Errorf("解压后的内容不正确：%s", body)
+		}
+	})
+	t.Run("小于最小大小时不压缩", func(t *testing.T) {
+		w := get("/small", "gzip, br")
+		if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "<p>ok</p>" {
+			t.Errorf("不应压缩：%v %s", w.Header(), w.Body.String())
+		}
+	})
+	t.Run("不在列表中的类型不压缩", func(t *testing.T) {
+		w := get("/png", "gzip, br")
+		if w.Header().Get("Content-Encoding") != "" || w.Body.String() != page {
+			t.Errorf("不应压缩：%v", w.Header())
+		}
+	})
+	t.Run("错误测试：写出少量内容后 panic", func(t *testing.T) {
+		router := gin.New()
+		router.Use(recovery(""), compress(conf))
+		router.GET("/panic", func(c *gin.Context) {
+			c.Header("Content-Type", "text/html; charset=utf-8")
+			c.Writer.WriteString("<p>部分内容</p>")
+			panic("测试 panic")
+		})
+		req := httptest.NewRequest("GET", "/panic", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusInternalServerError ||
+			w.Header().Get("Content-Encoding") != "" ||
+			strings.Contains(w.Body.String(), "部分内容") {
+			t.Errorf("返回 %d %v：%s，预期不压缩的 500 错误页面", w.Code, w.Header(),
+				w.Body.String())
+		}
+	})
+	t.Run("压缩模板生成的页面", func(t *testing.T) {
+		config := testConfig()
+		config.ServerConfig = "testdata/server_compress.toml"
+		s, err := New(config)
+		if err != nil {
+			t.Fatalf("创建服务失败：%v", err)
+		}
+		// 测试用的首页小于 min_size，使用较大的登录页面
+		req := httptest.NewRequest("GET", "/login", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		if w.Header().Get("Content-Encoding") != "gzip" ||
+			!strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+			t.Fatalf("页面没有压缩：%v", w.Header())
+		}
+		r, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(r)
+		if !strings.Contains(string(body), "<form method=\"post\" action=\"/login\">") {
+			t.Errorf("解压后的内容不正确：%s", body)
+		}
+	})
+}
+
+// @brief 测试静态文件与预压缩文件
+func TestServeStatic(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	st := &site{assets: &assets{}}
+	router.GET("/css/*filepath", serveStatic(Router{Type: "static", Path: "/css",
+		Dir: "testdata/static"}, st))
+	get := func(path string, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	t.Run("返回预压缩文件", func(t *testing.T) {
+		w := get("/css/style.css", "gzip")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" ||
+			!strings.HasPrefix(w.Header().Get("Content-Type"), "text/css") {
+			t.Fatalf("预压缩文件的响应头不正确：%d %v", w.Code, w.Header())
+		}
+		r, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(r)
+		if string(body) != "body { color: red; }\n" {
+			t.Errorf("解压后的内容不正确：%s", body)
+		}
+	})
+	t.Run("没有对应的预压缩文件", func(t *testing.T) {
+		w := get("/css/style.css", "br")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" ||
+			w.Body.String() != "body { color: red; }\n" {
+			t.Errorf("响应不正确：%d %v", w.Code, w.Header())
+		}
+	})
+	t.Run("错误测试：目录与不存在的文件", func(t *testing.T) {
+		for _, path := range []string{"/css/", "/css/none.css", "/css/../routing.toml"} {
+			if w := get(path, ""); w.Code != http.StatusNotFound {
+				t.Errorf("%s 返回 %d", path, w.Code)
+			}
+		}
+	})
+}
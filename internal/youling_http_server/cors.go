@@ -0,0 +1,234 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml"
+)
+
+// @brief 一个路由组的跨域访问（CORS）参数
+type corsPolicy struct {
+	Enabled          bool     `toml:"enabled"`           // 是否允许跨域访问
+	AllowedOrigins   []string `toml:"allowed_origins"`   // 允许的来源，* 表示所有来源
+	AllowedMethods   []string `toml:"allowed_methods"`   // 允许的请求方法
+	AllowedHeaders   []string `toml:"allowed_headers"`   // 允许的请求头，* 表示所有请求头
+	ExposedHeaders   []string `toml:"exposed_headers"`   // 脚本可以读取的响应头
+	AllowCredentials bool     `toml:"allow_credentials"` // 是否允许携带 Cookie
+	MaxAge           int      `toml:"max_age"`           // 预检结果的缓存时间（秒），0 表示不缓存
+}
+
+// @brief 跨域访问参数
+type corsConfig struct {
+	corsPolicy                       // 所有路由组默认使用的参数
+	groups     map[string]corsPolicy // 路由组自己的参数
+}
+
+// @brief 读取跨域访问参数
+//  @param file 服务器参数文件
+//  @return 跨域访问参数，失败时返回错误信息
+//  @remark [cors.groups.<组名>] 中没有设置的字段沿用 [cors] 中的设置。
+func readCORS(file string) (corsConfig, error) {
+	conf := corsConfig{corsPolicy: corsPolicy{
+		AllowedMethods: []string{http.MethodGet, http.MethodHead, http.MethodPost},
+		AllowedHeaders: []string{"Content-Type", "Authorization", csrfHeader},
+		ExposedHeaders: []string{requestIDHeader},
+	}, groups: map[string]corsPolicy{}}
+	config, err := loadToml(file)
+	if err != nil {
+		return conf, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	if !config.Has("cors") {
+		return conf, nil
+	}
+	tree := config.Get("cors").(*toml.Tree)
+	if err := tree.Unmarshal(&conf.corsPolicy); err != nil {
+		return conf, errors.New("解析服务器参数文件 " + file + " 中的 cors 时发生错误：" +
+			err.Error())
+	}
+	if err := conf.corsPolicy.check(); err != nil {
+		return conf, errors.New("服务器参数文件 " + file + " 中 cors " + err.Error())
+	}
+	groups, ok := tree.Get("groups").(*toml.Tree)
+	if !ok {
+		return conf, nil
+	}
+	for _, name := range groups.Keys() {
+		g, ok := groups.Get(name).(*toml.Tree)
+		if !ok {
+			return conf, errors.New("服务器参数文件 " + file + " 中 cors.groups." + name +
+				" 不是表。")
+		}
+		p := conf.corsPolicy
+		if err := g.Unmarshal(&p); err != nil {
+			return conf, errors.New("解析服务器参数文件 " + file + " 中的 cors.groups." +
+				name + " 时发生错误：" + err.Error())
+		}
+		if err := p.check(); err != nil {
+			return conf, errors.New("服务器参数文件 " + file + " 中 cors.groups." + name +
+				" " + err.Error())
+		}
+		conf.groups[name] = p
+	}
+	return conf, nil
+}
+
+// @brief 检查跨域访问参数
+//  @return 参数有效时返回 nil
+func (p corsPolicy) check() error {
+	if p.MaxAge < 0 {
+		return errors.New("的 max_age 字段无效。")
+	}
+	if p.AllowCredentials && containsFold(p.AllowedOrigins, "*") {
+		return errors.New("允许携带 Cookie 时 allowed_origins 不能为 *。")
+	}
+	return nil
+}
+
+// @brief 取得路由组使用的跨域访问参数
+//  @param group 路由组，为空时为 public 组
+func (conf corsConfig) policy(group string) corsPolicy {
+	if group == "" {
+		group = defaultRouteGroup
+	}
+	if p, ok := conf.groups[group]; ok {
+		return p
+	}
+	return conf.corsPolicy
+}
+
+// @brief 列表中是否包含指定的值，不区分大小写
+func containsFold(list []string, v string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// @brief 是否允许此来源
+func (p corsPolicy) allowOrigin(origin string) bool {
+	for _, o := range p.AllowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// @brief 是否允许预检请求中列出的所有请求头
+//  @param header Access-Control-Request-Headers 请求头
+func (p corsPolicy) allowHeaders(header string) bool {
+	if containsFold(p.AllowedHeaders, "*") {
+		return true
+	}
+	for _, h := range strings.Split(header, ",") {
+		if h = strings.TrimSpace(h); h != "" && !containsFold(p.AllowedHeaders, h) {
+			return false
+		}
+	}
+	return true
+}
+
+// @brief 设置跨域访问的来源与 Cookie 响应头
+//  @param c 上下文
+//  @param origin 请求的来源
+func (p corsPolicy) setOrigin(c *gin.Context, origin string) {
+	if containsFold(p.AllowedOrigins, "*") {
+		c.Header("Access-Control-Allow-Origin", "*")
+	} else {
+		c.Header("Access-Control-Allow-Origin", origin)
+	}
+	if p.AllowCredentials {
+		c.Header("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// @brief 路由的跨域访问中间件
+//  @param p 路由所属路由组的跨域访问参数
+//  @remark 来源不在允许的列表中时不设置 CORS 响应头，由浏览器拒绝脚本读取响应。
+func corsHeaders(p corsPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !p.Enabled {
+			c.Next()
+			return
+		}
+		// 响应随来源不同而不同，缓存需要区分来源
+		c.Writer.Header().Add("Vary", "Origin")
+		if origin := c.GetHeader("Origin"); origin != "" && p.allowOrigin(origin) {
+			p.setOrigin(c, origin)
+			if len(p.ExposedHeaders) > 0 {
+				c.Header("Access-Control-Expose-Headers",
+					strings.Join(p.ExposedHeaders, ", "))
+			}
+		}
+		c.Next()
+	}
+}
+
+// @brief 处理跨域访问的预检请求
+//  @param p 路由所属路由组的跨域访问参数
+//  @remark 来源、请求方法或请求头不被允许时返回 403，否则返回 204。
+//  预检请求不带登录凭据，所以不经过访问控制与 CSRF 检查。
+func preflight(p corsPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		method := c.GetHeader("Access-Control-Request-Method")
+		if origin == "" || method == "" {
+			// 不是预检请求
+			c.Header("Allow", strings.Join(p.AllowedMethods, ", "))
+			c.Status(http.StatusNoContent)
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+		c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+		headers := c.GetHeader("Access-Control-Request-Headers")
+		if !p.allowOrigin(origin) || !containsFold(p.AllowedMethods, method) ||
+			!p.allowHeaders(headers) {
+			appLog().Info("拒绝跨域预检请求", "request_id", c.GetString(ctxRequestID),
+				"origin", origin, "method", method, "headers", headers,
+				"path", c.Request.URL.Path)
+			abortWithError(c, http.StatusForbidden)
+			return
+		}
+		p.setOrigin(c, origin)
+		c.Header("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+		if headers != "" {
+			if containsFold(p.AllowedHeaders, "*") {
+				c.Header("Access-Control-Allow-Headers", headers)
+			} else {
+				c.Header("Access-Control-Allow-Headers",
+					strings.Join(p.AllowedHeaders, ", "))
+			}
+		}
+		if p.MaxAge > 0 {
+			c.Header("Access-Control-Max-Age", strconv.Itoa(p.MaxAge))
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// @brief 路由在 gin 中注册的路径
+//  @param r 路由
+func routePattern(r Router) string {
+	switch {
+	case r.Type == "static":
+		return r.Path + "/*filepath"
+	case r.Type == "function" && r.Function == "revokeToken":
+		return r.Path + "/:id"
+	}
+	return r.Path
+}
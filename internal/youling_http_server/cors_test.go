@@ -0,0 +1,123 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试跨域访问
+func TestCORS(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	config := testConfig()
+	config.ServerConfig = "testdata/server_cors.toml"
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	do := func(method string, path string,
+		header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w
+	}
+	preflightReq := func(path string, origin string, method string,
+		headers string) *httptest.ResponseRecorder {
+		return do("OPTIONS", path, map[string]string{"Origin": origin,
+			"Access-Control-Request-Method":  method,
+			"Access-Control-Request-Headers": headers})
+	}
+	t.Run("预检请求", func(t *testing.T) {
+		w := preflightReq("/", "http://localhost:5173", "POST", "content-type, x-csrf-token")
+		h := w.Header()
+		if w.Code != http.StatusNoContent ||
+			h.Get("Access-Control-Allow-Origin") != "http://localhost:5173" ||
+			h.Get("Access-Control-Allow-Credentials") != "true" ||
+			h.Get("Access-Control-Max-Age") != "600" ||
+			h.Get("Access-Control-Allow-Methods") != "GET, HEAD, POST" ||
+			h.Get("Access-Control-Allow-Headers") == "" {
+			t.Errorf("预检请求的响应不正确：%d %v", w.Code, h)
+		}
+	})
+	cases := []struct {
+		name    string
+		path    string
+		origin  string
+		method  string
+		headers string
+	}{
+		{"来源不允许", "/", "http://evil.example", "GET", ""},
+		{"请求方法不允许", "/", "http://localhost:5173", "DELETE", ""},
+		{"请求头不允许", "/", "http://localhost:5173", "GET", "X-Custom"},
+		{"路由组使用自己的来源", "/submit-data", "http://localhost:5173", "POST", ""},
+	}
+	for _, c := range cases {
+		t.Run("错误测试："+c.name, func(t *testing.T) {
+			w := preflightReq(c.path, c.origin, c.method, c.headers)
+			if w.Code != http.StatusForbidden ||
+				w.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Errorf("返回 %d %v，预期 403", w.Code, w.Header())
+			}
+		})
+	}
+	t.Run("路由组的参数沿用全局参数", func(t *testing.T) {
+		w := preflightReq("/submit-data", "http://admin.example", "POST", "")
+		if w.Code != http.StatusNoContent ||
+			w.Header().Get("Access-Control-Allow-Methods") != "POST" ||
+			w.Header().Get("Access-Control-Max-Age") != "600" {
+			t.Errorf("返回 %d %v", w.Code, w.Header())
+		}
+	})
+	t.Run("跨域请求", func(t *testing.T) {
+		w := do("GET", "/", map[string]string{"Origin": "http://localhost:5173"})
+		h := w.Header()
+		if w.Code != http.StatusOK ||
+			h.Get("Access-Control-Allow-Origin") != "http://localhost:5173" ||
+			h.Get("Access-Control-Expose-Headers") != requestIDHeader ||
+			h.Get("Vary") != "Origin" {
+			t.Errorf("跨域请求的响应不正确：%d %v", w.Code, h)
+		}
+		w = do("GET", "/", map[string]string{"Origin": "http://evil.example"})
+		if w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("来源不允许，但返回了 CORS 响应头：%v", w.Header())
+		}
+	})
+	t.Run("没有启用时不处理预检请求", func(t *testing.T) {
+		s, err := New(testConfig())
+		if err != nil {
+			t.Fatalf("创建服务失败：%v", err)
+		}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("OPTIONS", "/", nil)
+		req.Header.Set("Origin", "http://localhost:5173")
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		s.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("返回 %d，预期 404", w.Code)
+		}
+	})
+	t.Run("错误测试：允许携带 Cookie 时来源为 *", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "server.toml")
+		os.WriteFile(file, []byte("[cors]\nenabled = true\nallowed_origins = [\"*\"]\n"+
+			"allow_credentials = true\n"), 0600)
+		if _, err := readCORS(file); err == nil {
+			t.Errorf("参数无效，但没有报错。")
+		}
+	})
+}
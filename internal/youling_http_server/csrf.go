@@ -0,0 +1,146 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml"
+)
+
+// CSRF 令牌的请求头与表单字段
+const (
+	csrfHeader = "X-CSRF-Token"
+	csrfField  = "csrf_token"
+)
+
+// CSRF 令牌在 gin.Context 中的键名
+const ctxCSRFToken = "csrf_token"
+
+// @brief CSRF 防护参数
+type csrfConfig struct {
+	Enabled    bool   `toml:"enabled"`     // 是否检查 CSRF 令牌
+	CookieName string `toml:"cookie_name"` // 保存令牌的 Cookie 名称
+	Secure     bool   `toml:"secure"`      // Cookie 是否只通过 HTTPS 发送
+}
+
+// @brief 读取 CSRF 防护参数
+//  @param file 服务器参数文件
+//  @return CSRF 防护参数，失败时返回错误信息
+func readCSRF(file string) (csrfConfig, error) {
+	conf := csrfConfig{Enabled: true, CookieName: "sunflower_csrf"}
+	config, err := loadToml(file)
+	if err != nil {
+		return conf, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	if !config.Has("csrf") {
+		return conf, nil
+	}
+	if err := config.Get("csrf").(*toml.Tree).Unmarshal(&conf); err != nil {
+		return conf, errors.New("解析服务器参数文件 " + file + " 中的 csrf 时发生错误：" +
+			err.Error())
+	}
+	if conf.CookieName == "" {
+		return conf, errors.New("服务器参数文件 " + file + " 中 csrf.cookie_name 字段无效。")
+	}
+	return conf, nil
+}
+
+// @brief 生成新的 CSRF 令牌
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// @brief CSRF 令牌是否合法
+//  @remark 只接受 16～128 个 base64url 字符。
+func validCSRFToken(token string) bool {
+	if len(token) < 16 || len(token) > 128 {
+		return false
+	}
+	_, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil
+}
+
+// @brief 是否为会改变状态的请求方法
+func unsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
+
+// @brief CSRF 令牌中间件
+//  @param conf CSRF 防护参数
+//  @remark 使用双重提交 Cookie：令牌保存在 Cookie 中，页面通过 <!--{{.csrf_token}}-->
+//  占位符取得同一个令牌，提交时放在 X-CSRF-Token 请求头或 csrf_token 表单字段中。
+//  Cookie 中没有令牌时生成新的令牌，检查由各路由的 checkCSRF 进行。
+func csrf(conf csrfConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !conf.Enabled {
+			c.Next()
+			return
+		}
+		token, err := c.Cookie(conf.CookieName)
+		if err != nil || !validCSRFToken(token) {
+			token = newCSRFToken()
+			http.SetCookie(c.Writer, &http.Cookie{
+				Name:     conf.CookieName,
+				Value:    token,
+				Path:     "/",
+				Secure:   conf.Secure,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		c.Set(ctxCSRFToken, token)
+		c.Next()
+	}
+}
+
+// @brief 检查 CSRF 令牌
+//  @param conf CSRF 防护参数
+//  @remark POST、PUT、PATCH、DELETE 请求提交的令牌与 Cookie 中的不一致时返回 403。
+//  使用 API 令牌的请求不经过浏览器，不需要检查。
+func checkCSRF(conf csrfConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !conf.Enabled || !unsafeMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if _, ok := c.Get(ctxToken); ok {
+			c.Next()
+			return
+		}
+		token := c.GetString(ctxCSRFToken)
+		sent := c.GetHeader(csrfHeader)
+		if sent == "" {
+			// 只有表单提交时才读取表单字段，避免读取其它类型的请求内容
+			switch c.ContentType() {
+			case "application/x-www-form-urlencoded", "multipart/form-data":
+				sent = c.PostForm(csrfField)
+			}
+		}
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			appLog().Info("CSRF 令牌不正确", "request_id", c.GetString(ctxRequestID),
+				"method", c.Request.Method, "path", c.Request.URL.Path,
+				"client_ip", clientIP(c))
+			abortWithError(c, http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
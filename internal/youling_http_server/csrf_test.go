@@ -0,0 +1,106 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 测试用的 CSRF 令牌
+var testCSRFToken = strings.Repeat("a", 43)
+
+// @brief 给请求加上 CSRF Cookie 与请求头
+//  @remark 需要在设置 Cookie 请求头之后调用。
+func addCSRF(req *http.Request) {
+	req.AddCookie(&http.Cookie{Name: "sunflower_csrf", Value: testCSRFToken})
+	req.Header.Set(csrfHeader, testCSRFToken)
+}
+
+// @brief 测试 CSRF 防护
+func TestCSRF(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	config := testConfig()
+	config.ServerConfig = "testdata/server_users.toml"
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	login := func(form url.Values, header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: "sunflower_csrf", Value: testCSRFToken})
+		if header != "" {
+			req.Header.Set(csrfHeader, header)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w
+	}
+	form := url.Values{"username": {"alice"}, "password": {"secret"}}
+	t.Run("打开页面时设置 Cookie 并填入令牌", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/login", nil))
+		var token string
+		for _, c := range w.Result().Cookies() {
+			if c.Name == "sunflower_csrf" {
+				token = c.Value
+			}
+		}
+		if !validCSRFToken(token) {
+			t.Fatalf("没有设置 CSRF Cookie：%v", w.Header())
+		}
+		if !strings.Contains(w.Body.String(), `value="`+token+`"`) {
+			t.Errorf("页面中没有 CSRF 令牌：%s", w.Body.String())
+		}
+	})
+	t.Run("请求头中的令牌", func(t *testing.T) {
+		if w := login(form, testCSRFToken); w.Code != http.StatusSeeOther {
+			t.Errorf("返回 %d，预期 303", w.Code)
+		}
+	})
+	t.Run("表单中的令牌", func(t *testing.T) {
+		f := url.Values{"csrf_token": {testCSRFToken}}
+		for k, v := range form {
+			f[k] = v
+		}
+		if w := login(f, ""); w.Code != http.StatusSeeOther {
+			t.Errorf("返回 %d，预期 303", w.Code)
+		}
+	})
+	t.Run("错误测试：没有令牌", func(t *testing.T) {
+		if w := login(form, ""); w.Code != http.StatusForbidden {
+			t.Errorf("返回 %d，预期 403", w.Code)
+		}
+	})
+	t.Run("错误测试：令牌与 Cookie 不一致", func(t *testing.T) {
+		if w := login(form, strings.Repeat("b", 43)); w.Code != http.StatusForbidden {
+			t.Errorf("返回 %d，预期 403", w.Code)
+		}
+	})
+	t.Run("使用 API 令牌的请求不检查", func(t *testing.T) {
+		_, token, err := createToken(s.tokens, "bob", "", nil, 0)
+		if err != nil {
+			t.Fatalf("创建令牌失败：%v", err)
+		}
+		req := httptest.NewRequest("POST", "/submit-data", strings.NewReader("test"))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		if w.Code == http.StatusForbidden {
+			t.Errorf("使用 API 令牌的请求被 CSRF 防护拒绝。")
+		}
+	})
+}
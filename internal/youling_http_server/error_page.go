@@ -0,0 +1,100 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 站点内容在 gin.Context 中的键名
+const ctxSite = "site"
+
+// @brief 把站点内容放入上下文，供错误页面等使用
+//  @param st 站点内容
+func withSite(st *site) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(ctxSite, st)
+		c.Next()
+	}
+}
+
+// @brief 替换页面中与请求有关的占位符
+//  @param page 页面内容
+//  @param values 占位符名称与替换内容
+//  @return 页面内容
+//  @remark 占位符与模板中的写法相同，例如 <!--{{.request_id}}-->。
+func replaceRequestPlaceHolder(page string, values map[string]string) string {
+	for k, v := range values {
+		page = strings.Replace(page, "<!--{{."+k+"}}-->", v, -1)
+	}
+	return page
+}
+
+// @brief 与请求有关的占位符
+//  @param c 上下文
+//  @return 占位符名称与替换内容，内容已转义，可以直接放入 HTML
+//  @remark request_id 为请求 id，user 为登录的用户名，
+//  next 为登录后返回的页面（只允许本站的路径），csrf_token 为提交表单时需要的令牌，
+//  csp_nonce 为 Content-Security-Policy 中允许的内联脚本与样式的 nonce。
+func requestPlaceHolders(c *gin.Context) map[string]string {
+	return map[string]string{
+		"request_id": html.EscapeString(c.GetString(ctxRequestID)),
+		"user":       html.EscapeString(c.GetString(ctxUser)),
+		"next":       html.EscapeString(localRedirect(c.Query("next"))),
+		"csrf_token": html.EscapeString(c.GetString(ctxCSRFToken)),
+		"csp_nonce":  html.EscapeString(c.GetString(ctxCSPNonce)),
+	}
+}
+
+// @brief 生成错误页面
+//  @param status 状态码
+//  @return 页面内容，没有配置此状态码的错误页面时返回 false
+func (st *site) renderError(status int) (string, bool) {
+	for _, e := range st.routers.Errors {
+		if e.Status == status {
+			return st.render(Router{Type: "error", Function: e.Function,
+				Template: e.Template, Replacement: e.Replacement}), true
+		}
+	}
+	return "", false
+}
+
+// @brief 返回错误页面并停止处理请求
+//  @param c 上下文
+//  @param status 状态码
+//  @remark 客户端接受 application/json 时返回 json，否则按 routing.toml 中的
+//  [[errors]] 用模板生成页面，没有配置时返回纯文本。
+//  错误页面中带有请求 id，便于和服务器日志对应。
+func abortWithError(c *gin.Context, status int) {
+	c.Abort()
+	id := c.GetString(ctxRequestID)
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(status, gin.H{"status": status,
+			"error": http.StatusText(status), "request_id": id})
+		return
+	}
+	v, _ := c.Get(ctxSite)
+	if st, ok := v.(*site); ok {
+		if page, ok := st.renderError(status); ok {
+			values := requestPlaceHolders(c)
+			values["status"] = strconv.Itoa(status)
+			page = replaceRequestPlaceHolder(page, values)
+			c.Data(status, "text/html; charset=utf-8", []byte(page))
+			return
+		}
+	}
+	c.String(status, fmt.Sprintf("%d %s\n请求编号：%s\n", status,
+		http.StatusText(status), id))
+}
@@ -0,0 +1,67 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试错误页面
+func TestErrorPage(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	s, err := New(testConfig())
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	t.Run("用模板生成 404 页面", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/none", nil)
+		req.Header.Set("Accept", "text/html,*/*")
+		s.Handler().ServeHTTP(w, req)
+		id := w.Header().Get(requestIDHeader)
+		want := "<html><body>\n<p>404 请求编号：" + id + "</p>\n</body></html>"
+		if w.Code != http.StatusNotFound || w.Body.String() != want {
+			t.Errorf("404 页面不正确：%d %q", w.Code, w.Body.String())
+		}
+	})
+	t.Run("客户端接受 json 时返回 json", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/none", nil)
+		req.Header.Set("Accept", "application/json")
+		s.Handler().ServeHTTP(w, req)
+		var m map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+			t.Fatalf("返回的不是 json：%s", w.Body.String())
+		}
+		if m["status"] != float64(404) ||
+			m["request_id"] != w.Header().Get(requestIDHeader) {
+			t.Errorf("json 内容不正确：%v", m)
+		}
+	})
+	t.Run("没有配置的状态码返回纯文本", func(t *testing.T) {
+		st, _ := loadSite(testConfig())
+		router, err := s.newRouter(st, nil)
+		if err != nil {
+			t.Fatalf("创建路由失败：%v", err)
+		}
+		router.GET("/panic", func(c *gin.Context) { panic("测试") })
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+		if w.Code != http.StatusInternalServerError ||
+			!strings.Contains(w.Body.String(), w.Header().Get(requestIDHeader)) {
+			t.Errorf("500 页面不正确：%d %q", w.Code, w.Body.String())
+		}
+	})
+}
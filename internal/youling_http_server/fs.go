@@ -0,0 +1,95 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pelletier/go-toml"
+)
+
+// @brief 读取参数文件、模板与静态文件使用的文件系统
+//  @remark 没有嵌入文件时直接读取磁盘。嵌入文件后优先读取嵌入的文件，
+//  找不到时再读取磁盘；开发时可以改为优先读取磁盘，修改文件后不需要重新编译。
+//  证书与日志等文件总是在磁盘上，不经过这里。
+type siteFS struct {
+	embedded   fs.FS // 嵌入的文件，为 nil 时只读取磁盘
+	preferDisk bool  // 是否优先读取磁盘上的文件
+}
+
+// 当前使用的文件系统，由 UseFS 在 New 之前设置
+var siteFiles fs.FS = siteFS{}
+
+// @brief 设置嵌入的文件
+//  @param embedded 嵌入的文件，文件路径与工作目录下的相对路径一致
+//  @param preferDisk 是否优先读取磁盘上的文件
+//  @remark 需要在 New 之前调用。
+func UseFS(embedded fs.FS, preferDisk bool) {
+	siteFiles = siteFS{embedded: embedded, preferDisk: preferDisk}
+}
+
+// @brief 打开文件
+//  @param name 文件路径，可以是磁盘上的绝对路径
+func (s siteFS) Open(name string) (fs.File, error) {
+	if s.embedded == nil {
+		return os.Open(name)
+	}
+	if s.preferDisk {
+		f, err := os.Open(name)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
+		return s.embedded.Open(embeddedName(name))
+	}
+	f, err := s.embedded.Open(embeddedName(name))
+	if err == nil {
+		return f, nil
+	}
+	// 嵌入的文件中没有时读取磁盘，例如绝对路径
+	return os.Open(name)
+}
+
+// @brief 把路径转换成嵌入文件中的路径，例如 ./static/css 转换成 static/css
+func embeddedName(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
+
+// @brief 读取文件内容
+//  @param name 文件路径
+func readFile(name string) ([]byte, error) {
+	return fs.ReadFile(siteFiles, name)
+}
+
+// @brief 载入 TOML 文件
+//  @param name 文件路径
+func loadToml(name string) (*toml.Tree, error) {
+	content, err := readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return toml.LoadBytes(content)
+}
+
+// @brief 以某个目录为根目录的文件系统，供静态路由使用
+type dirFS string
+
+func (d dirFS) Open(name string) (fs.File, error) {
+	return siteFiles.Open(path.Join(string(d), name))
+}
+
+// @brief 返回以 dir 为根目录的 http 文件系统
+//  @param dir 目录
+func staticDir(dir string) http.FileSystem {
+	return http.FS(dirFS(dir))
+}
@@ -0,0 +1,61 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+// @brief 测试嵌入文件与磁盘文件的读取顺序
+func TestSiteFS(t *testing.T) {
+	embedded := fstest.MapFS{
+		"testdata/server_local.toml": {Data: []byte("# embedded\n")},
+		"embedded/only.txt":          {Data: []byte("embedded only")},
+	}
+	defer UseFS(nil, false)
+	t.Run("优先读取嵌入的文件", func(t *testing.T) {
+		UseFS(embedded, false)
+		for name, want := range map[string]string{
+			"./testdata/server_local.toml": "# embedded\n",
+			"embedded/only.txt":            "embedded only",
+		} {
+			content, err := readFile(name)
+			if err != nil || string(content) != want {
+				t.Errorf("%s 读到 %q %v", name, content, err)
+			}
+		}
+		// 嵌入的文件中没有时读取磁盘
+		if _, err := readFile("testdata/routing.toml"); err != nil {
+			t.Errorf("没有读取磁盘上的文件：%v", err)
+		}
+	})
+	t.Run("优先读取磁盘上的文件", func(t *testing.T) {
+		UseFS(embedded, true)
+		content, err := readFile("testdata/server_local.toml")
+		if err != nil || string(content) == "# embedded\n" {
+			t.Errorf("没有优先读取磁盘上的文件：%q %v", content, err)
+		}
+		if content, err := readFile("embedded/only.txt"); err != nil ||
+			string(content) != "embedded only" {
+			t.Errorf("磁盘上没有时没有读取嵌入的文件：%q %v", content, err)
+		}
+	})
+	t.Run("静态文件目录", func(t *testing.T) {
+		UseFS(embedded, false)
+		f, err := staticDir("./embedded").Open("/only.txt")
+		if err != nil {
+			t.Fatalf("打开静态文件失败：%v", err)
+		}
+		f.Close()
+		if _, err := staticDir("./embedded").Open("/none.txt"); err == nil {
+			t.Errorf("文件不存在，但没有报错。")
+		}
+	})
+}
@@ -7,3 +7,31 @@
 // license that can be found in the LICENSE file or at
 // https://opensource.org/licenses/MIT.
 package youling_http_server
+
+import (
+	"net/http"
+	"sync"
+)
+
+// @brief 可替换的 http 处理器
+//  @remark 重新载入配置时先建好新的路由，再整体替换，
+//  替换前已经开始处理的请求继续使用旧的路由。
+type reloadableHandler struct {
+	mu      sync.RWMutex
+	handler http.Handler
+}
+
+// @brief 替换处理器
+//  @param h 新的处理器
+func (rh *reloadableHandler) set(h http.Handler) {
+	rh.mu.Lock()
+	rh.handler = h
+	rh.mu.Unlock()
+}
+
+func (rh *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rh.mu.RLock()
+	h := rh.handler
+	rh.mu.RUnlock()
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,213 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+//go:build !windows
+
+package youling_http_server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 平滑重启时交接监听所用的环境变量
+const (
+	envListeners = "SUNFLOWER_LISTENERS" // 交接的监听，依次对应从 3 开始的文件描述符
+	envReadyFD   = "SUNFLOWER_READY_FD"  // 新进程开始处理请求后写入此文件描述符通知旧进程
+)
+
+// 等待新进程开始处理请求的最长时间
+const handoffTimeout = 30 * time.Second
+
+// 收到后平滑重启的信号
+var restartSignals = []os.Signal{syscall.SIGUSR2}
+
+var (
+	inheritOnce sync.Once
+	inherited   map[string]net.Listener // 从旧进程继承的监听
+	inheritErr  error
+)
+
+// @brief 监听在交接时使用的名称
+func listenerKey(l Listener) string {
+	return l.Network + ":" + l.Address
+}
+
+// @brief 取出从旧进程继承的监听
+//  @param l 监听配置
+//  @return 继承的监听，没有时返回 nil
+func inheritedListener(l Listener) (net.Listener, error) {
+	inheritOnce.Do(func() {
+		keys := os.Getenv(envListeners)
+		os.Unsetenv(envListeners)
+		if keys == "" {
+			return
+		}
+		n := len(strings.Split(keys, ","))
+		files := make([]*os.File, n)
+		for i := range files {
+			files[i] = os.NewFile(uintptr(3+i), "listener")
+		}
+		inherited, inheritErr = listenersFromFiles(keys, files)
+	})
+	if inheritErr != nil {
+		return nil, inheritErr
+	}
+	ln := inherited[listenerKey(l)]
+	delete(inherited, listenerKey(l))
+	return ln, nil
+}
+
+// @brief 关闭没有用到的继承监听
+//  @remark 在取出所有监听之后调用。参数中已经删除的监听不再使用，
+//  unix 套接字文件同时删除。
+func closeInherited() {
+	for key, ln := range inherited {
+		if ul, ok := ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(true)
+		}
+		ln.Close()
+		delete(inherited, key)
+		appLog().Info("关闭参数中已经没有的继承监听", "listener", key)
+	}
+}
+
+// @brief 把文件还原成监听
+//  @param keys 以逗号分隔的监听名称，与文件一一对应
+//  @param files 监听的文件
+//  @return 以监听名称为键的监听表，失败时返回错误信息
+func listenersFromFiles(keys string, files []*os.File) (map[string]net.Listener,
+	error) {
+	names := strings.Split(keys, ",")
+	if len(names) != len(files) {
+		return nil, errors.New("继承的监听数量与文件数量不一致。")
+	}
+	lns := make(map[string]net.Listener)
+	for i, f := range files {
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.New("还原继承的监听 " + names[i] + " 失败：" +
+				err.Error())
+		}
+		lns[names[i]] = ln
+	}
+	return lns, nil
+}
+
+// @brief 通知旧进程新进程已经开始处理请求
+func notifyReady() {
+	fd, err := strconv.Atoi(os.Getenv(envReadyFD))
+	os.Unsetenv(envReadyFD)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte("ready"))
+	f.Close()
+}
+
+// @brief 平滑重启
+//  @return 新进程已开始处理请求：nil，失败：错误信息（此时旧进程继续处理请求）
+//  @remark 以相同的参数启动新的可执行文件，并把所有监听交给新进程。
+//  新进程开始处理请求后，调用者应关闭本服务，未完成的请求会按 ShutdownTimeout 处理完。
+func (s *Server) Restart() error {
+	// 1. 取出所有监听的文件
+	s.mu.Lock()
+	var keys []string
+	var files []*os.File
+	var unix []*net.UnixListener
+	handedOff := false
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+		// 交接失败时旧进程继续使用套接字文件，关闭时仍然需要删除
+		if !handedOff {
+			for _, ul := range unix {
+				ul.SetUnlinkOnClose(true)
+			}
+		}
+	}()
+	for _, ep := range s.endpoints {
+		if ep.ln == nil {
+			s.mu.Unlock()
+			return errors.New("服务还没有开始监听。")
+		}
+		fl, ok := ep.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			s.mu.Unlock()
+			return errors.New("监听 " + ep.Address + " 不支持交接。")
+		}
+		f, err := fl.File()
+		if err != nil {
+			s.mu.Unlock()
+			return errors.New("取出监听 " + ep.Address + " 的文件失败：" + err.Error())
+		}
+		// 套接字文件交给新进程使用，旧进程关闭时不能删除
+		if ul, ok := ep.ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+			unix = append(unix, ul)
+		}
+		keys = append(keys, listenerKey(ep.Listener))
+		files = append(files, f)
+	}
+	s.mu.Unlock()
+	// 2. 启动新进程，监听依次放在从 3 开始的文件描述符上，最后是通知用的管道
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.New("找不到可执行文件：" + err.Error())
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return errors.New("创建管道失败：" + err.Error())
+	}
+	defer r.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		envListeners+"="+strings.Join(keys, ","),
+		fmt.Sprintf("%s=%d", envReadyFD, 3+len(files)))
+	cmd.ExtraFiles = append(files, w)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return errors.New("启动新进程失败：" + err.Error())
+	}
+	// 3. 等待新进程通知，新进程退出时管道关闭，读取会立即返回
+	ready := make(chan bool, 1)
+	go func() {
+		buf := make([]byte, 5)
+		n, _ := r.Read(buf)
+		ready <- n > 0
+	}()
+	select {
+	case ok := <-ready:
+		if !ok {
+			cmd.Wait()
+			return errors.New("新进程没有开始处理请求就退出了。")
+		}
+	case <-time.After(handoffTimeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errors.New("等待新进程开始处理请求超时。")
+	}
+	handedOff = true
+	return nil
+}
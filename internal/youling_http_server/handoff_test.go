@@ -0,0 +1,123 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+//go:build !windows
+
+package youling_http_server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// @brief 测试 listenersFromFiles 函数
+func TestListenersFromFiles(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("打开 tcp 监听失败：%v", err)
+	}
+	defer tcp.Close()
+	unix, err := net.Listen("unix", filepath.Join(t.TempDir(), "s.sock"))
+	if err != nil {
+		t.Fatalf("打开 unix 监听失败：%v", err)
+	}
+	defer unix.Close()
+	var files []*os.File
+	for _, ln := range []net.Listener{tcp, unix} {
+		f, err := ln.(interface{ File() (*os.File, error) }).File()
+		if err != nil {
+			t.Fatalf("取出监听文件失败：%v", err)
+		}
+		files = append(files, f)
+	}
+	keys := listenerKey(Listener{Network: "tcp", Address: "127.0.0.1:8080"}) +
+		"," + listenerKey(Listener{Network: "unix", Address: "s.sock"})
+	t.Run("正常测试", func(t *testing.T) {
+		lns, err := listenersFromFiles(keys, files)
+		if err != nil {
+			t.Fatalf("还原监听失败：%v", err)
+		}
+		defer func() {
+			for _, ln := range lns {
+				ln.Close()
+			}
+		}()
+		ln := lns["tcp:127.0.0.1:8080"]
+		if ln == nil || ln.Addr().String() != tcp.Addr().String() {
+			t.Errorf("还原的 tcp 监听不正确。")
+		}
+		ln = lns["unix:s.sock"]
+		if ln == nil || ln.Addr().String() != unix.Addr().String() {
+			t.Errorf("还原的 unix 监听不正确。")
+		}
+	})
+	t.Run("错误测试：数量不一致", func(t *testing.T) {
+		if _, err := listenersFromFiles("tcp:a", files); err == nil {
+			t.Errorf("监听数量与文件数量不一致，但没有报错。")
+		}
+	})
+}
+
+// @brief 测试交接失败后恢复 unix 套接字文件的删除
+func TestRestartFailed(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "s.sock")
+	unix, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("打开 unix 监听失败：%v", err)
+	}
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("打开 tcp 监听失败：%v", err)
+	}
+	defer tcp.Close()
+	// 不支持取出文件的监听，使交接在 unix 监听之后失败
+	type noFile struct{ net.Listener }
+	s := &Server{endpoints: []*endpoint{
+		{Listener: Listener{Network: "unix", Address: sock}, ln: unix},
+		{Listener: Listener{Network: "tcp", Address: "127.0.0.1:0"}, ln: noFile{tcp}},
+	}}
+	if s.Restart() == nil {
+		t.Fatalf("监听不支持交接，但没有报错。")
+	}
+	unix.Close()
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("交接失败后关闭监听，但套接字文件没有删除：%v", err)
+	}
+}
+
+// @brief 测试关闭没有用到的继承监听
+func TestCloseInherited(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "s.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("打开 unix 监听失败：%v", err)
+	}
+	// 模拟从旧进程继承：旧进程不删除套接字文件，新进程从文件还原监听
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	f, err := ln.(*net.UnixListener).File()
+	ln.Close()
+	if err != nil {
+		t.Fatalf("取出监听文件失败：%v", err)
+	}
+	lns, err := listenersFromFiles("unix:"+sock, []*os.File{f})
+	if err != nil {
+		t.Fatalf("还原监听失败：%v", err)
+	}
+	inherited = lns
+	defer func() { inherited = nil }()
+	closeInherited()
+	if len(inherited) != 0 {
+		t.Errorf("还有没有关闭的继承监听：%v", inherited)
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("没有用到的 unix 监听已关闭，但套接字文件没有删除：%v", err)
+	}
+}
@@ -0,0 +1,34 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// Windows 不支持通过文件描述符交接监听，没有平滑重启信号
+var restartSignals = []os.Signal{}
+
+// @brief 取出从旧进程继承的监听，Windows 下总是没有
+func inheritedListener(l Listener) (net.Listener, error) {
+	return nil, nil
+}
+
+// @brief 关闭没有用到的继承监听，Windows 下没有继承的监听
+func closeInherited() {}
+
+// @brief 通知旧进程新进程已经开始处理请求，Windows 下不需要
+func notifyReady() {}
+
+// @brief 平滑重启，Windows 下不支持
+func (s *Server) Restart() error {
+	return errors.New("Windows 下不支持平滑重启。")
+}
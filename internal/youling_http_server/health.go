@@ -0,0 +1,116 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 就绪检查项
+type readinessCheck struct {
+	name  string       // 检查项名称
+	check func() error // 检查函数，返回 nil 表示通过
+}
+
+// @brief 是否正在关闭服务
+func (s *Server) draining() bool {
+	return atomic.LoadInt32(&s.drain) != 0
+}
+
+// @brief 标记为正在关闭服务，此后就绪检查失败
+func (s *Server) startDraining() {
+	atomic.StoreInt32(&s.drain, 1)
+}
+
+// @brief 就绪检查项列表
+//  @param st 站点内容
+func (s *Server) readinessChecks(st *site) []readinessCheck {
+	return []readinessCheck{
+		{"shutdown", func() error {
+			if s.draining() {
+				return errors.New("正在关闭服务")
+			}
+			return nil
+		}},
+		{"templates", st.checkTemplates},
+		{"storage", st.checkStorage},
+		{"users", s.users.Check},
+		{"sessions", s.sessions.store.Check},
+		{"tokens", s.tokens.Check},
+	}
+}
+
+// @brief 检查模板是否已经载入
+func (st *site) checkTemplates() error {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if len(st.templates) == 0 {
+		return errors.New("没有载入模板")
+	}
+	for _, r := range st.routers.Routing {
+		if r.Type != "template" {
+			continue
+		}
+		if _, ok := st.templates[r.Template]; !ok {
+			return errors.New("找不到模板 " + r.Template)
+		}
+	}
+	return nil
+}
+
+// @brief 检查静态文件目录是否可以访问
+func (st *site) checkStorage() error {
+	for _, r := range st.routers.Routing {
+		if r.Type != "static" {
+			continue
+		}
+		if _, err := fs.Stat(siteFiles, r.Dir); err != nil {
+			return errors.New("无法访问目录 " + r.Dir)
+		}
+	}
+	return nil
+}
+
+// @brief 存活检查，进程能处理请求即返回成功
+//  @param c 上下文
+func handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// @brief 就绪检查
+//  @param checks 检查项列表
+//  @return 处理函数，全部检查通过时返回 200，否则返回 503
+//  @remark 就绪检查不需要登录，响应中每项只给出 ok 或 fail，
+//  失败的原因（可能包含文件路径等）只写到日志中。
+func handleReadyz(checks []readinessCheck) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := http.StatusOK
+		results := gin.H{}
+		for _, ck := range checks {
+			if err := ck.check(); err != nil {
+				status = http.StatusServiceUnavailable
+				results[ck.name] = "fail"
+				appLog().Info("就绪检查失败", "request_id", c.GetString(ctxRequestID),
+					"check", ck.name, "error", err)
+			} else {
+				results[ck.name] = "ok"
+			}
+		}
+		if status == http.StatusOK {
+			c.JSON(status, gin.H{"status": "ok", "checks": results})
+		} else {
+			c.JSON(status, gin.H{"status": "unavailable", "checks": results})
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试存活与就绪检查
+func TestHealth(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	s, err := New(testConfig())
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	get := func(path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var m map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &m)
+		return w.Code, m
+	}
+	t.Run("存活检查", func(t *testing.T) {
+		if code, _ := get("/healthz"); code != http.StatusOK {
+			t.Errorf("存活检查返回 %d", code)
+		}
+	})
+	t.Run("就绪检查：正常", func(t *testing.T) {
+		code, m := get("/readyz")
+		if code != http.StatusOK || m["status"] != "ok" {
+			t.Errorf("就绪检查返回 %d：%v", code, m)
+		}
+	})
+	t.Run("就绪检查：开始关闭后失败", func(t *testing.T) {
+		s.startDraining()
+		code, m := get("/readyz")
+		if code != http.StatusServiceUnavailable {
+			t.Errorf("开始关闭后就绪检查返回 %d", code)
+		}
+		if checks, _ := m["checks"].(map[string]interface{}); checks["shutdown"] != "fail" ||
+			checks["templates"] != "ok" {
+			t.Errorf("检查结果不正确：%v", m)
+		}
+		if code, _ := get("/healthz"); code != http.StatusOK {
+			t.Errorf("开始关闭后存活检查返回 %d", code)
+		}
+	})
+	t.Run("就绪检查：没有模板", func(t *testing.T) {
+		st := &site{templates: map[string]string{}}
+		if st.checkTemplates() == nil {
+			t.Errorf("没有模板，但检查通过。")
+		}
+	})
+}
@@ -0,0 +1,70 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// 每个连接默认允许同时处理的请求流数量
+const defaultMaxConcurrentStreams = 250
+
+// @brief HTTP/2 参数
+type http2Config struct {
+	H2C                  bool   // 未加密的监听是否处理 HTTP/2 明文（h2c）
+	MaxConcurrentStreams uint32 // 每个连接允许同时处理的请求流数量
+}
+
+// @brief 读取 HTTP/2 参数
+//  @param file 服务器参数文件
+//  @return HTTP/2 参数，失败时返回错误信息
+func readHTTP2(file string) (http2Config, error) {
+	conf := http2Config{MaxConcurrentStreams: defaultMaxConcurrentStreams}
+	config, err := loadToml(file)
+	if err != nil {
+		return conf, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	if h2c, ok := config.GetDefault("http2.h2c", false).(bool); ok {
+		conf.H2C = h2c
+	} else {
+		return conf, errors.New("服务器参数文件 " + file + " 中 http2.h2c 字段无效。")
+	}
+	streams, ok := config.GetDefault("http2.max_concurrent_streams",
+		int64(defaultMaxConcurrentStreams)).(int64)
+	if !ok || streams <= 0 || streams > math.MaxUint32 {
+		return conf, errors.New("服务器参数文件 " + file +
+			" 中 http2.max_concurrent_streams 字段无效。")
+	}
+	conf.MaxConcurrentStreams = uint32(streams)
+	return conf, nil
+}
+
+// @brief 为 http 服务器设置 HTTP/2
+//  @param srv http服务器，已设置好 Handler 与 TLSConfig
+//  @param conf HTTP/2 参数
+//  @return 成功：nil，失败：错误信息
+//  @remark 使用 TLS 时通过 ALPN 协商 HTTP/2；未加密时只有启用 h2c 才处理 HTTP/2。
+func configureHTTP2(srv *http.Server, conf http2Config) error {
+	h2s := &http2.Server{MaxConcurrentStreams: conf.MaxConcurrentStreams}
+	if srv.TLSConfig != nil {
+		if err := http2.ConfigureServer(srv, h2s); err != nil {
+			return errors.New("设置 HTTP/2 失败：" + err.Error())
+		}
+		return nil
+	}
+	if conf.H2C {
+		srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/http2"
+)
+
+// @brief 测试 readHTTP2 函数
+func TestReadHTTP2(t *testing.T) {
+	t.Run("正常测试：默认参数", func(t *testing.T) {
+		conf, err := readHTTP2("testdata/server_config.toml")
+		if err != nil {
+			t.Errorf("读取 HTTP/2 参数失败：%v", err)
+		}
+		if conf.H2C || conf.MaxConcurrentStreams != defaultMaxConcurrentStreams {
+			t.Errorf("默认参数不正确：%+v", conf)
+		}
+	})
+	t.Run("正常测试：启用 h2c", func(t *testing.T) {
+		conf, err := readHTTP2("testdata/server_h2c.toml")
+		if err != nil {
+			t.Errorf("读取 HTTP/2 参数失败：%v", err)
+		}
+		if !conf.H2C || conf.MaxConcurrentStreams != 100 {
+			t.Errorf("参数不正确：%+v", conf)
+		}
+	})
+	t.Run("错误测试：参数无效", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "server.toml")
+		for _, v := range []string{"h2c = 1", "max_concurrent_streams = 0",
+			"max_concurrent_streams = -1", "max_concurrent_streams = 4294967297",
+			`max_concurrent_streams = "100"`} {
+			os.WriteFile(file, []byte("[http2]\n"+v+"\n"), 0600)
+			if _, err := readHTTP2(file); err == nil {
+				t.Errorf("%s：参数无效，但没有报错。", v)
+			}
+		}
+	})
+}
+
+// @brief 测试通过 h2c 访问
+func TestH2C(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	config := testConfig()
+	config.ServerConfig = "testdata/server_h2c.toml"
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Start(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	// 等待开始监听，取得实际端口
+	var addr string
+	for i := 0; i < 50 && addr == ""; i++ {
+		time.Sleep(20 * time.Millisecond)
+		s.mu.Lock()
+		if s.endpoints[0].ln != nil {
+			addr = s.endpoints[0].ln.Addr().String()
+		}
+		s.mu.Unlock()
+	}
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		}}}
+	resp, err := client.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatalf("通过 h2c 访问失败：%v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 || resp.StatusCode != http.StatusOK {
+		t.Errorf("h2c 访问返回 %s %d", resp.Proto, resp.StatusCode)
+	}
+}
@@ -0,0 +1,81 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// @brief 监听配置结构
+type Listener struct {
+	Network string   // 网络类型：tcp 或 unix
+	Address string   // 监听地址，unix 类型时为套接字文件路径
+	Groups  []string // 处理的路由组，为空时处理所有路由组
+}
+
+type Listeners struct {
+	Listeners []Listener // 监听列表
+}
+
+// @brief 读取监听列表
+//  @param file 服务器参数文件
+//  @return 监听列表，失败时返回错误信息
+//  @remark 没有 [[listeners]] 时使用 [server] 中的 address 与 port，
+//  并处理所有路由组。
+func readListeners(file string) ([]Listener, error) {
+	conf, err := loadToml(file)
+	if err != nil {
+		return nil, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	if !conf.Has("listeners") {
+		address, ok1 := conf.Get("server.address").(string)
+		port, ok2 := conf.Get("server.port").(string)
+		if !ok1 || !ok2 {
+			return nil, errors.New("服务器参数文件 " + file +
+				" 中找不到 server.address 或 server.port 字段。")
+		}
+		return []Listener{{Network: "tcp", Address: address + ":" + port}}, nil
+	}
+	var ls Listeners
+	if err := conf.Unmarshal(&ls); err != nil {
+		return nil, errors.New("解析服务器参数文件 " + file + " 中的 listeners 时发生错误：" +
+			err.Error())
+	}
+	for _, l := range ls.Listeners {
+		if l.Network != "tcp" && l.Network != "unix" {
+			return nil, errors.New(fmt.Sprintf("未知监听类型：%s", l.Network))
+		}
+		if l.Address == "" {
+			return nil, errors.New("服务器参数文件 " + file + " 中的监听缺少 address 字段。")
+		}
+	}
+	return ls.Listeners, nil
+}
+
+// @brief 打开监听
+//  @param l 监听配置
+//  @return 监听，失败时返回错误信息
+func listen(l Listener) (net.Listener, error) {
+	if l.Network == "unix" {
+		// 上次异常退出时留下的套接字文件会导致监听失败，先删除
+		if fi, err := os.Lstat(l.Address); err == nil &&
+			fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(l.Address)
+		}
+	}
+	ln, err := net.Listen(l.Network, l.Address)
+	if err != nil {
+		return nil, errors.New("监听 " + l.Network + " " + l.Address + " 失败：" +
+			err.Error())
+	}
+	return ln, nil
+}
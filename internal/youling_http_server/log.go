@@ -0,0 +1,320 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 结构化日志
+//  @remark 每条日志占一行，格式为 json 或 logfmt。
+//  访问日志与程序日志使用同一个日志输出。
+type logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string // json 或 logfmt
+}
+
+// @brief 创建结构化日志
+//  @param out 日志输出
+//  @param format 日志格式：json 或 logfmt
+func newLogger(out io.Writer, format string) *logger {
+	return &logger{out: out, format: format}
+}
+
+// 当前使用的程序日志，New 根据服务器参数文件重新设置
+var currentLog atomic.Value
+
+func init() {
+	currentLog.Store(newLogger(os.Stdout, "json"))
+}
+
+// @brief 返回当前使用的程序日志
+func appLog() *logger {
+	return currentLog.Load().(*logger)
+}
+
+// @brief 替换当前使用的程序日志，原来的日志文件会被关闭
+func setAppLog(l *logger) {
+	old := currentLog.Swap(l).(*logger)
+	if w, ok := old.out.(*rotateWriter); ok && old.out != l.out {
+		w.Close()
+	}
+}
+
+// @brief 记录一般信息
+//  @param msg 日志内容
+//  @param kv 依次为字段名与字段值
+func (l *logger) Info(msg string, kv ...interface{}) {
+	l.write("info", msg, kv)
+}
+
+// @brief 记录错误信息
+//  @param msg 日志内容
+//  @param kv 依次为字段名与字段值
+func (l *logger) Error(msg string, kv ...interface{}) {
+	l.write("error", msg, kv)
+}
+
+// @brief 按日志格式写入一行日志
+func (l *logger) write(level string, msg string, kv []interface{}) {
+	fields := append([]interface{}{
+		"time", time.Now().Format(time.RFC3339Nano),
+		"level", level,
+		"msg", msg,
+	}, kv...)
+	var buf bytes.Buffer
+	if l.format == "logfmt" {
+		encodeLogfmt(&buf, fields)
+	} else {
+		encodeJSON(&buf, fields)
+	}
+	buf.WriteByte('\n')
+	l.mu.Lock()
+	l.out.Write(buf.Bytes())
+	l.mu.Unlock()
+}
+
+// @brief 把字段值转换成可以写入日志的值
+func logValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case error:
+		return x.Error()
+	case time.Duration:
+		// 以毫秒为单位
+		return float64(x) / float64(time.Millisecond)
+	case fmt.Stringer:
+		return x.String()
+	}
+	return v
+}
+
+// @brief 以 json 格式编码字段，字段顺序与调用时一致
+func encodeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i+1 < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(logValue(fields[i+1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(fields[i+1]))
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+}
+
+// @brief 以 logfmt 格式编码字段
+func encodeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+		value := fmt.Sprint(logValue(fields[i+1]))
+		if needsQuote(value) {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+}
+
+// @brief logfmt 中的值是否需要加引号
+func needsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r == ' ' || r == '=' || r == '"' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// @brief 访问日志中间件
+//  @remark 每个请求结束后记录请求 id、方法、路径、状态码、耗时、响应大小、
+//  客户端 IP 与用户。
+func accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		appLog().Info("access",
+			"request_id", c.GetString(ctxRequestID),
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency_ms", time.Since(start),
+			"bytes", c.Writer.Size(),
+			"client_ip", clientIP(c),
+			"user", c.GetString(ctxUser))
+	}
+}
+
+// @brief 按大小与时间轮转的日志文件
+type rotateWriter struct {
+	mu         sync.Mutex
+	file       string        // 日志文件
+	maxSize    int64         // 超过此大小（字节）时轮转，0 表示不按大小轮转
+	maxAge     time.Duration // 文件打开超过此时间时轮转，0 表示不按时间轮转
+	maxBackups int           // 最多保留的旧日志数量，0 表示不限
+	f          *os.File
+	size       int64     // 当前文件大小
+	opened     time.Time // 当前文件的打开时间
+}
+
+// @brief 打开按大小与时间轮转的日志文件
+//  @return 日志文件，失败时返回错误信息
+func newRotateWriter(file string, maxSize int64, maxAge time.Duration,
+	maxBackups int) (*rotateWriter, error) {
+	w := &rotateWriter{file: file, maxSize: maxSize, maxAge: maxAge,
+		maxBackups: maxBackups}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// @brief 打开日志文件，已存在时追加
+func (w *rotateWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.file), 0755); err != nil {
+		return errors.New("创建日志目录失败：" + err.Error())
+	}
+	f, err := os.OpenFile(w.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.New("打开日志文件 " + w.file + " 失败：" + err.Error())
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.New("读取日志文件 " + w.file + " 失败：" + err.Error())
+	}
+	w.f = f
+	w.size = fi.Size()
+	w.opened = time.Now()
+	return nil
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return 0, errors.New("日志文件已关闭。")
+	}
+	if (w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize) ||
+		(w.maxAge > 0 && time.Since(w.opened) > w.maxAge) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// @brief 轮转日志文件：当前文件改名为带时间的旧日志，再打开新文件
+func (w *rotateWriter) rotate() error {
+	w.f.Close()
+	w.f = nil
+	backup := w.file + "." + time.Now().Format("20060102-150405.000000")
+	if err := os.Rename(w.file, backup); err != nil {
+		return errors.New("轮转日志文件 " + w.file + " 失败：" + err.Error())
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.prune()
+	return nil
+}
+
+// @brief 删除超出数量的旧日志
+func (w *rotateWriter) prune() {
+	if w.maxBackups <= 0 {
+		return
+	}
+	backups, _ := filepath.Glob(w.file + ".*")
+	// 旧日志以时间命名，按名称排序即按时间排序
+	sort.Strings(backups)
+	for len(backups) > w.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+func (w *rotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// @brief 读取日志参数并创建日志
+//  @param file 服务器参数文件
+//  @return 日志，失败时返回错误信息
+func readLogger(file string) (*logger, error) {
+	config, err := loadToml(file)
+	if err != nil {
+		return nil, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	format, _ := config.GetDefault("log.format", "json").(string)
+	if format != "json" && format != "logfmt" {
+		return nil, errors.New(fmt.Sprintf("未知日志格式：%s", format))
+	}
+	logFile, _ := config.GetDefault("log.file", "").(string)
+	if logFile == "" {
+		return newLogger(os.Stdout, format), nil
+	}
+	maxSize, _ := config.GetDefault("log.max_size", int64(0)).(int64)
+	maxAge, _ := config.GetDefault("log.max_age", int64(0)).(int64)
+	maxBackups, _ := config.GetDefault("log.max_backups", int64(0)).(int64)
+	w, err := newRotateWriter(logFile, maxSize*1024*1024,
+		time.Duration(maxAge)*24*time.Hour, int(maxBackups))
+	if err != nil {
+		return nil, err
+	}
+	return newLogger(w, format), nil
+}
+
+// @brief 读取崩溃报告目录
+//  @param file 服务器参数文件
+//  @return 崩溃报告目录，没有设置时为空，失败时返回错误信息
+func readCrashDir(file string) (string, error) {
+	config, err := loadToml(file)
+	if err != nil {
+		return "", errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	dir, ok := config.GetDefault("log.crash_dir", "").(string)
+	if !ok {
+		return "", errors.New("服务器参数文件 " + file + " 中 log.crash_dir 字段无效。")
+	}
+	return dir, nil
+}
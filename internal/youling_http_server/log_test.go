@@ -0,0 +1,111 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试结构化日志的格式
+func TestLogger(t *testing.T) {
+	t.Run("json 格式", func(t *testing.T) {
+		var buf bytes.Buffer
+		newLogger(&buf, "json").Error("出错了", "error", errors.New("原因"),
+			"latency_ms", 1500*time.Microsecond, "status", 404)
+		var m map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+			t.Fatalf("日志不是合法的 json：%s", buf.String())
+		}
+		if m["level"] != "error" || m["msg"] != "出错了" || m["error"] != "原因" ||
+			m["latency_ms"] != 1.5 || m["status"] != float64(404) {
+			t.Errorf("日志内容不正确：%s", buf.String())
+		}
+		if !strings.HasPrefix(buf.String(), `{"time":`) {
+			t.Errorf("字段顺序不正确：%s", buf.String())
+		}
+	})
+	t.Run("logfmt 格式", func(t *testing.T) {
+		var buf bytes.Buffer
+		newLogger(&buf, "logfmt").Info("完成", "path", "/a b", "user", "",
+			"status", 200)
+		line := buf.String()
+		if !strings.Contains(line, ` level=info msg=完成 path="/a b" user="" status=200`) {
+			t.Errorf("日志内容不正确：%s", line)
+		}
+	})
+}
+
+// @brief 测试访问日志中间件
+func TestAccessLog(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	var buf bytes.Buffer
+	setAppLog(newLogger(&buf, "json"))
+	defer setAppLog(newLogger(os.Stdout, "json"))
+	router := gin.New()
+	router.Use(requestID(), accessLog())
+	router.GET("/a", func(c *gin.Context) {
+		c.Set("user", "alice")
+		c.String(200, "hello")
+	})
+	req := httptest.NewRequest("GET", "/a", nil)
+	req.Header.Set("X-Request-ID", "abc")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("访问日志不是合法的 json：%s", buf.String())
+	}
+	if m["request_id"] != "abc" || m["method"] != "GET" || m["path"] != "/a" ||
+		m["status"] != float64(200) || m["bytes"] != float64(5) ||
+		m["user"] != "alice" || m["client_ip"] == "" {
+		t.Errorf("访问日志内容不正确：%s", buf.String())
+	}
+}
+
+// @brief 测试日志文件轮转
+func TestRotateWriter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "logs", "access.log")
+	w, err := newRotateWriter(file, 10, 0, 2)
+	if err != nil {
+		t.Fatalf("打开日志文件失败：%v", err)
+	}
+	defer w.Close()
+	for i := 0; i < 5; i++ {
+		if _, err := w.Write([]byte("12345678\n")); err != nil {
+			t.Fatalf("写入日志失败：%v", err)
+		}
+	}
+	backups, _ := filepath.Glob(file + ".*")
+	if len(backups) != 2 {
+		t.Errorf("旧日志数量不正确：%d", len(backups))
+	}
+	data, _ := os.ReadFile(file)
+	if string(data) != "12345678\n" {
+		t.Errorf("当前日志内容不正确：%q", data)
+	}
+	t.Run("按时间轮转", func(t *testing.T) {
+		w.maxSize = 0
+		w.maxAge = time.Millisecond
+		time.Sleep(2 * time.Millisecond)
+		w.Write([]byte("new\n"))
+		data, _ := os.ReadFile(file)
+		if string(data) != "new\n" {
+			t.Errorf("超过时间后没有轮转：%q", data)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 用户名在 gin.Context 中的键名，访问日志中的 user 字段取自这里
+const ctxUser = "user"
+
+// @brief 登录
+//  @param st 站点内容
+//  @param r 路由，登录失败时用其中的模板与替换文件重新生成登录页面
+//  @remark 登录页面的表单提交 username、password 与 next（登录后返回的页面）。
+//  登录失败时返回 401，页面中的 <!--{{.login_error}}--> 替换为错误信息。
+func (s *Server) handleLogin(st *site, r Router) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.PostForm("username")
+		u, err := authenticate(s.users, name, c.PostForm("password"))
+		if err != nil {
+			appLog().Info("登录失败", "request_id", c.GetString(ctxRequestID),
+				"user", name, "client_ip", clientIP(c), "error", err)
+			if r.Template == "" || r.Template == "nil" {
+				abortWithError(c, http.StatusUnauthorized)
+				return
+			}
+			values := requestPlaceHolders(c)
+			values["login_error"] = "用户名或密码错误。"
+			values["next"] = html.EscapeString(localRedirect(c.PostForm("next")))
+			page := replaceRequestPlaceHolder(st.render(r), values)
+			c.Data(http.StatusUnauthorized, "text/html; charset=utf-8",
+				[]byte(page))
+			return
+		}
+		if err := s.sessions.start(c, u.Name); err != nil {
+			appLog().Error("创建会话失败", "request_id", c.GetString(ctxRequestID),
+				"user", u.Name, "error", err)
+			abortWithError(c, http.StatusInternalServerError)
+			return
+		}
+		appLog().Info("登录成功", "request_id", c.GetString(ctxRequestID),
+			"user", u.Name, "client_ip", clientIP(c))
+		c.Redirect(http.StatusSeeOther, localRedirect(c.PostForm("next")))
+	}
+}
+
+// @brief 退出登录，删除当前会话
+//  @param c 上下文
+func (s *Server) handleLogout(c *gin.Context) {
+	s.sessions.end(c)
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+// @brief 检查跳转地址，只允许本站的路径
+//  @param next 跳转地址
+//  @return 本站的路径，其它地址一律返回首页
+//  @remark 浏览器会去掉网址中的制表符、换行等字符，并把 \ 当作 /，
+//  所以含有控制字符或空白的地址一律拒绝，例如 /\t/example.com 会变成 //example.com。
+func localRedirect(next string) string {
+	if strings.IndexFunc(next, func(r rune) bool {
+		return unicode.IsControl(r) || unicode.IsSpace(r)
+	}) >= 0 {
+		return "/"
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil ||
+		!strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") ||
+		strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
@@ -0,0 +1,296 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 耗时直方图的分桶上限（秒），与 Prometheus 客户端的默认值一致
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// @brief 直方图
+type histogram struct {
+	counts []uint64 // 各分桶的数量（不累计）
+	sum    float64  // 所有观测值之和
+	count  uint64   // 观测次数
+}
+
+// @brief 记录一次观测值
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(defaultBuckets))
+	}
+	for i, b := range defaultBuckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// @brief 请求计数的标签
+type requestLabels struct {
+	path, typ, method, status string
+}
+
+// @brief 路由耗时的标签
+type routeLabels struct {
+	path, typ string
+}
+
+// @brief 页面生成耗时的标签
+type renderLabels struct {
+	template, page string
+}
+
+// @brief 运行指标
+//  @remark 以 Prometheus 文本格式输出，不依赖外部服务。
+type metrics struct {
+	mu             sync.Mutex
+	requests       map[requestLabels]uint64
+	durations      map[routeLabels]*histogram
+	renders        map[renderLabels]*histogram
+	reloads        uint64 // 模板重新载入次数
+	reloadFailures uint64 // 模板重新载入失败次数
+	start          time.Time
+}
+
+// @brief 创建运行指标
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  make(map[requestLabels]uint64),
+		durations: make(map[routeLabels]*histogram),
+		renders:   make(map[renderLabels]*histogram),
+		start:     time.Now(),
+	}
+}
+
+// 进程内所有服务共用的运行指标
+var appMetrics = newMetrics()
+
+// @brief 作为标签的请求方法
+//  @param method 请求方法
+//  @return 标准请求方法原样返回，其它方法返回 OTHER，避免任意方法名不断增加时间序列
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodConnect, http.MethodOptions,
+		http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// @brief 记录一次请求
+//  @param r 路由（routing.toml 中的一项）
+//  @param method 请求方法
+//  @param status 状态码
+//  @param d 耗时
+func (m *metrics) observeRequest(r Router, method string, status int,
+	d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{r.Path, r.Type, metricMethod(method),
+		strconv.Itoa(status)}]++
+	l := routeLabels{r.Path, r.Type}
+	h := m.durations[l]
+	if h == nil {
+		h = &histogram{}
+		m.durations[l] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// @brief 记录一次页面生成
+//  @param r 路由
+//  @param d 耗时
+func (m *metrics) observeRender(r Router, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := renderLabels{r.Template, r.Function}
+	h := m.renders[l]
+	if h == nil {
+		h = &histogram{}
+		m.renders[l] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// @brief 记录一次模板重新载入
+//  @param err 重新载入的结果
+func (m *metrics) observeReload(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reloads++
+	if err != nil {
+		m.reloadFailures++
+	}
+}
+
+// @brief 路由指标中间件
+//  @param r 路由，以 routing.toml 中的 path 与 type 作为标签
+func routeMetrics(r Router) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		appMetrics.observeRequest(r, c.Request.Method, c.Writer.Status(),
+			time.Since(start))
+	}
+}
+
+// @brief 输出 Prometheus 文本格式的指标
+func (m *metrics) writeTo(w io.Writer) {
+	var buf bytes.Buffer
+	m.mu.Lock()
+	// 1. 请求数量
+	writeHeader(&buf, "sunflower_http_requests_total", "counter",
+		"按路由、方法与状态码统计的请求数量。")
+	reqs := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		reqs = append(reqs, l)
+	}
+	sort.Slice(reqs, func(i, j int) bool {
+		a, b := reqs[i], reqs[j]
+		return a.path+"\x00"+a.typ+"\x00"+a.method+"\x00"+a.status <
+			b.path+"\x00"+b.typ+"\x00"+b.method+"\x00"+b.status
+	})
+	for _, l := range reqs {
+		fmt.Fprintf(&buf, "sunflower_http_requests_total{%s} %d\n",
+			labels("path", l.path, "type", l.typ, "method", l.method,
+				"status", l.status), m.requests[l])
+	}
+	// 2. 请求耗时
+	writeHeader(&buf, "sunflower_http_request_duration_seconds", "histogram",
+		"按路由统计的请求耗时。")
+	routes := make([]routeLabels, 0, len(m.durations))
+	for l := range m.durations {
+		routes = append(routes, l)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].path+"\x00"+routes[i].typ <
+			routes[j].path+"\x00"+routes[j].typ
+	})
+	for _, l := range routes {
+		writeHistogram(&buf, "sunflower_http_request_duration_seconds",
+			labels("path", l.path, "type", l.typ), m.durations[l])
+	}
+	// 3. 页面生成耗时
+	writeHeader(&buf, "sunflower_template_render_duration_seconds", "histogram",
+		"按模板与页面统计的页面生成耗时。")
+	renders := make([]renderLabels, 0, len(m.renders))
+	for l := range m.renders {
+		renders = append(renders, l)
+	}
+	sort.Slice(renders, func(i, j int) bool {
+		return renders[i].template+"\x00"+renders[i].page <
+			renders[j].template+"\x00"+renders[j].page
+	})
+	for _, l := range renders {
+		writeHistogram(&buf, "sunflower_template_render_duration_seconds",
+			labels("template", l.template, "page", l.page), m.renders[l])
+	}
+	// 4. 模板重新载入
+	writeHeader(&buf, "sunflower_template_reloads_total", "counter",
+		"模板重新载入次数。")
+	fmt.Fprintf(&buf, "sunflower_template_reloads_total %d\n", m.reloads)
+	writeHeader(&buf, "sunflower_template_reload_failures_total", "counter",
+		"模板重新载入失败次数。")
+	fmt.Fprintf(&buf, "sunflower_template_reload_failures_total %d\n",
+		m.reloadFailures)
+	start := m.start
+	m.mu.Unlock()
+	// 5. Go 运行时
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	writeHeader(&buf, "go_info", "gauge", "Go 版本。")
+	fmt.Fprintf(&buf, "go_info{%s} 1\n", labels("version", runtime.Version()))
+	writeGauge(&buf, "go_goroutines", "当前 goroutine 数量。",
+		float64(runtime.NumGoroutine()))
+	writeGauge(&buf, "go_memstats_alloc_bytes", "已分配且仍在使用的堆内存字节数。",
+		float64(ms.Alloc))
+	writeGauge(&buf, "go_memstats_heap_inuse_bytes", "正在使用的堆内存字节数。",
+		float64(ms.HeapInuse))
+	writeGauge(&buf, "go_memstats_sys_bytes", "从操作系统获得的内存字节数。",
+		float64(ms.Sys))
+	writeHeader(&buf, "go_gc_cycles_total", "counter", "已完成的垃圾回收次数。")
+	fmt.Fprintf(&buf, "go_gc_cycles_total %d\n", ms.NumGC)
+	writeHeader(&buf, "go_gc_pause_seconds_total", "counter",
+		"垃圾回收暂停的总时间。")
+	fmt.Fprintf(&buf, "go_gc_pause_seconds_total %s\n",
+		formatFloat(float64(ms.PauseTotalNs)/1e9))
+	writeGauge(&buf, "process_start_time_seconds", "进程启动时间（Unix 时间，秒）。",
+		float64(start.UnixNano())/1e9)
+	w.Write(buf.Bytes())
+}
+
+// @brief 输出指标的 HELP 与 TYPE 行
+func writeHeader(buf *bytes.Buffer, name string, typ string, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// @brief 输出一个 gauge 指标
+func writeGauge(buf *bytes.Buffer, name string, help string, v float64) {
+	writeHeader(buf, name, "gauge", help)
+	fmt.Fprintf(buf, "%s %s\n", name, formatFloat(v))
+}
+
+// @brief 输出一个直方图
+func writeHistogram(buf *bytes.Buffer, name string, lbs string, h *histogram) {
+	var cum uint64
+	for i, b := range defaultBuckets {
+		cum += h.counts[i]
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n", name, lbs,
+			formatFloat(b), cum)
+	}
+	fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, lbs, h.count)
+	fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, lbs, formatFloat(h.sum))
+	fmt.Fprintf(buf, "%s_count{%s} %d\n", name, lbs, h.count)
+}
+
+// @brief 生成标签字符串
+//  @param kv 依次为标签名与标签值
+func labels(kv ...string) string {
+	parts := make([]string, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		parts = append(parts, kv[i]+"=\""+escapeLabel(kv[i+1])+"\"")
+	}
+	return strings.Join(parts, ",")
+}
+
+// @brief 转义标签值中的反斜杠、双引号与换行
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// @brief 按 Prometheus 的习惯格式化浮点数
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// @brief 输出指标
+//  @param c 上下文
+func handleMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(200)
+	appMetrics.writeTo(c.Writer)
+}
@@ -0,0 +1,78 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试指标的输出格式
+func TestMetricsWriteTo(t *testing.T) {
+	m := newMetrics()
+	r := Router{Type: "template", Path: "/", Function: "homepage",
+		Template: "general1"}
+	m.observeRequest(r, "GET", 200, 30*time.Millisecond)
+	m.observeRequest(r, "GET", 200, 2*time.Second)
+	m.observeRequest(r, "FOO1", 404, time.Millisecond)
+	m.observeRequest(r, "FOO2", 404, time.Millisecond)
+	m.observeRender(r, time.Millisecond)
+	m.observeReload(nil)
+	m.observeReload(errors.New("失败"))
+	var buf bytes.Buffer
+	m.writeTo(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		`sunflower_http_requests_total{path="/",type="template",method="GET",status="200"} 2`,
+		`sunflower_http_requests_total{path="/",type="template",method="OTHER",status="404"} 2`,
+		`sunflower_http_request_duration_seconds_bucket{path="/",type="template",le="0.05"} 3`,
+		`sunflower_http_request_duration_seconds_bucket{path="/",type="template",le="2.5"} 4`,
+		`sunflower_http_request_duration_seconds_bucket{path="/",type="template",le="+Inf"} 4`,
+		`sunflower_http_request_duration_seconds_count{path="/",type="template"} 4`,
+		`sunflower_template_render_duration_seconds_count{template="general1",page="homepage"} 1`,
+		`sunflower_template_reloads_total 2`,
+		`sunflower_template_reload_failures_total 1`,
+		`# TYPE go_goroutines gauge`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("指标中缺少：%s", line)
+		}
+	}
+	t.Run("标签转义", func(t *testing.T) {
+		if labels("path", "a\"b\\c\n") != `path="a\"b\\c\n"` {
+			t.Errorf("标签值没有正确转义：%s", labels("path", "a\"b\\c\n"))
+		}
+	})
+}
+
+// @brief 测试 /metrics 路由
+func TestMetricsRoute(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	s, err := New(testConfig())
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/",
+		nil))
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Content-Type 不正确：%s", w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(),
+		`sunflower_http_requests_total{path="/",type="template",method="GET",status="200"}`) {
+		t.Errorf("指标中没有首页的请求数量。")
+	}
+}
@@ -0,0 +1,190 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求 id 的请求头与响应头
+const requestIDHeader = "X-Request-ID"
+
+// 请求 id 在 gin.Context 中的键名
+const ctxRequestID = "request_id"
+
+// 请求 id 在 context.Context 中的键
+type requestIDKey struct{}
+
+// @brief 请求 id 中间件
+//  @remark 客户端或代理传来合法的 X-Request-ID 时沿用，否则生成新的请求 id。
+//  请求 id 放入上下文与日志，并在响应头中返回。
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(ctxRequestID, id)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(),
+			requestIDKey{}, id))
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// @brief 从 context.Context 中取出请求 id
+//  @return 请求 id，没有时返回空字符串
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// @brief 生成新的请求 id
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// @brief 请求 id 是否合法
+//  @remark 只接受不超过 128 个字符的字母、数字与 -_.:，避免日志注入。
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// @brief 生成路由自己的中间件
+//  @param s http 服务
+//  @param st 站点内容
+//  @param r 路由
+func routeMiddleware(s *Server, st *site, r Router) []gin.HandlerFunc {
+	return []gin.HandlerFunc{routeMetrics(r), corsHeaders(s.cors.policy(r.Group)),
+		routeHeaders(r), rateLimit(s.limiters.get(r), r), authorize(s, st, r),
+		checkRequestBody(r), checkCSRF(s.csrf)}
+}
+
+// @brief 异常恢复中间件
+//  @param crashDir 崩溃报告目录，为空时不写崩溃报告
+//  @remark 处理请求时发生 panic，记录带请求 id 与调用栈的日志，
+//  返回 500 错误页面，并可把崩溃报告写入目录供以后查看。
+func recovery(crashDir string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			stack := debug.Stack()
+			id := c.GetString(ctxRequestID)
+			// 客户端已断开连接时无法再返回内容，只记录日志
+			if brokenPipe(err) {
+				appLog().Error("客户端断开连接", "request_id", id,
+					"path", c.Request.URL.Path, "error", fmt.Sprint(err))
+				c.Abort()
+				return
+			}
+			appLog().Error("处理请求时发生 panic", "request_id", id,
+				"method", c.Request.Method, "path", c.Request.URL.Path,
+				"panic", fmt.Sprint(err), "stack", string(stack))
+			if crashDir != "" {
+				if file, e := writeCrashReport(crashDir, c, err, stack); e != nil {
+					appLog().Error("写入崩溃报告失败", "request_id", id, "error", e)
+				} else {
+					appLog().Info("已写入崩溃报告", "request_id", id, "file", file)
+				}
+			}
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			abortWithError(c, http.StatusInternalServerError)
+		}()
+		c.Next()
+	}
+}
+
+// @brief panic 是否由客户端断开连接引起
+func brokenPipe(err interface{}) bool {
+	e, ok := err.(error)
+	if !ok {
+		return false
+	}
+	var ne *net.OpError
+	if !errors.As(e, &ne) {
+		return false
+	}
+	return errors.Is(ne, syscall.EPIPE) || errors.Is(ne, syscall.ECONNRESET)
+}
+
+// @brief 写入崩溃报告
+//  @param dir 崩溃报告目录
+//  @param c 上下文
+//  @param err panic 的值
+//  @param stack 调用栈
+//  @return 崩溃报告文件，失败时返回错误信息
+func writeCrashReport(dir string, c *gin.Context, err interface{},
+	stack []byte) (string, error) {
+	if e := os.MkdirAll(dir, 0700); e != nil {
+		return "", errors.New("创建崩溃报告目录失败：" + e.Error())
+	}
+	now := time.Now()
+	id := c.GetString(ctxRequestID)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "时间：%s\n", now.Format(time.RFC3339Nano))
+	fmt.Fprintf(&buf, "请求编号：%s\n", id)
+	fmt.Fprintf(&buf, "请求：%s %s\n", c.Request.Method, c.Request.URL.String())
+	fmt.Fprintf(&buf, "客户端：%s\n", clientIP(c))
+	fmt.Fprintf(&buf, "panic：%v\n\n请求头：\n", err)
+	names := make([]string, 0, len(c.Request.Header))
+	for k := range c.Request.Header {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		// 不记录登录凭据
+		if k == "Cookie" || k == "Authorization" {
+			continue
+		}
+		for _, v := range c.Request.Header[k] {
+			fmt.Fprintf(&buf, "  %s: %s\n", k, v)
+		}
+	}
+	fmt.Fprintf(&buf, "\n调用栈：\n%s", stack)
+	file := filepath.Join(dir, "crash-"+now.Format("20060102-150405.000000")+
+		"-"+id+".txt")
+	if e := os.WriteFile(file, buf.Bytes(), 0600); e != nil {
+		return "", errors.New("写入崩溃报告 " + file + " 失败：" + e.Error())
+	}
+	return file, nil
+}
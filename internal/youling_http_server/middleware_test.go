@@ -0,0 +1,101 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试请求 id 中间件
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(requestID())
+	router.GET("/", func(c *gin.Context) {
+		// 上下文与 context.Context 中的请求 id 应当一致
+		c.String(200, c.GetString(ctxRequestID)+" "+
+			requestIDFrom(c.Request.Context()))
+	})
+	router.NoRoute(func(c *gin.Context) {
+		abortWithError(c, http.StatusNotFound)
+	})
+	t.Run("沿用客户端的请求 id", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(requestIDHeader, "abc-123")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Header().Get(requestIDHeader) != "abc-123" ||
+			w.Body.String() != "abc-123 abc-123" {
+			t.Errorf("请求 id 不正确：%s %s", w.Header().Get(requestIDHeader),
+				w.Body.String())
+		}
+	})
+	t.Run("生成新的请求 id", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(requestIDHeader, "bad id\n")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		id := w.Header().Get(requestIDHeader)
+		if len(id) != 32 || w.Body.String() != id+" "+id {
+			t.Errorf("请求 id 不正确：%s %s", id, w.Body.String())
+		}
+	})
+	t.Run("错误页面中带有请求 id", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/none", nil))
+		id := w.Header().Get(requestIDHeader)
+		if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), id) {
+			t.Errorf("错误页面不正确：%d %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+// @brief 测试异常恢复中间件
+func TestRecovery(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	var buf bytes.Buffer
+	setAppLog(newLogger(&buf, "json"))
+	defer setAppLog(newLogger(os.Stdout, "json"))
+	dir := t.TempDir()
+	router := gin.New()
+	router.Use(requestID(), recovery(dir))
+	router.GET("/panic", func(c *gin.Context) { panic("测试 panic") })
+	req := httptest.NewRequest("GET", "/panic", nil)
+	req.Header.Set("Cookie", "session=secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	id := w.Header().Get(requestIDHeader)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("发生 panic 后返回 %d", w.Code)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(bytes.SplitN(buf.Bytes(), []byte("\n"), 2)[0], &m)
+	if m["panic"] != "测试 panic" || m["request_id"] != id ||
+		!strings.Contains(m["stack"].(string), "middleware_test.go") {
+		t.Errorf("panic 日志不正确：%s", buf.String())
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "crash-*-"+id+".txt"))
+	if len(files) != 1 {
+		t.Fatalf("没有写入崩溃报告。")
+	}
+	report, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(report), "测试 panic") ||
+		strings.Contains(string(report), "secret") {
+		t.Errorf("崩溃报告内容不正确：%s", report)
+	}
+}
@@ -0,0 +1,289 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml"
+)
+
+// 请求协议与客户端 IP 在 gin.Context 中的键名
+const (
+	ctxScheme   = "scheme"
+	ctxClientIP = "client_ip"
+)
+
+// trusted_proxies 中表示信任 unix 套接字对端的名称
+const trustUnixSocket = "unix"
+
+// @brief 反向代理参数
+type proxyConfig struct {
+	// 可信代理的 IP 或网段，只采用这些代理传来的 X-Forwarded-For、Forwarded 与
+	// X-Forwarded-Proto，为空时不信任任何代理。unix 表示信任 unix 套接字的对端
+	TrustedProxies []string `toml:"trusted_proxies"`
+	// 是否把 http 请求跳转到 https
+	RedirectHTTPS bool `toml:"redirect_https"`
+	nets          []*net.IPNet
+	unix          bool // 是否信任 unix 套接字的对端
+}
+
+// @brief 读取反向代理参数
+//  @param file 服务器参数文件
+//  @return 反向代理参数，失败时返回错误信息
+func readProxy(file string) (proxyConfig, error) {
+	conf := proxyConfig{}
+	config, err := loadToml(file)
+	if err != nil {
+		return conf, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	if !config.Has("proxy") {
+		return conf, nil
+	}
+	if err := config.Get("proxy").(*toml.Tree).Unmarshal(&conf); err != nil {
+		return conf, errors.New("解析服务器参数文件 " + file + " 中的 proxy 时发生错误：" +
+			err.Error())
+	}
+	for _, p := range conf.TrustedProxies {
+		if p == trustUnixSocket {
+			conf.unix = true
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			// 单个 IP 按只包含这一个地址的网段处理
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return conf, errors.New("服务器参数文件 " + file +
+				" 中 trusted_proxies 的地址无效：" + p)
+		}
+		conf.nets = append(conf.nets, n)
+	}
+	return conf, nil
+}
+
+// @brief 交给 gin 的可信代理列表
+//  @remark gin 只处理 IP 与网段，unix 套接字的对端由 proxy 中间件处理。
+func (conf proxyConfig) ginProxies() []string {
+	var list []string
+	for _, p := range conf.TrustedProxies {
+		if p != trustUnixSocket {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// @brief 请求是否来自 unix 套接字
+//  @param c 上下文
+func fromUnixSocket(c *gin.Context) bool {
+	addr, ok := c.Request.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// @brief 是否为可信代理的 IP
+func (conf proxyConfig) trustedIP(ip net.IP) bool {
+	for _, n := range conf.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// @brief 请求是否来自可信代理
+//  @param c 上下文
+func (conf proxyConfig) trusted(c *gin.Context) bool {
+	if fromUnixSocket(c) {
+		return conf.unix
+	}
+	ip := net.ParseIP(c.RemoteIP())
+	return ip != nil && conf.trustedIP(ip)
+}
+
+// @brief 从右向左跳过可信代理，取得客户端在列表中的位置
+//  @param ips 代理依次追加的地址，无法解析的为 nil
+//  @return 从右向左第一个不是可信代理的位置，都是可信代理时返回 0，
+//  遇到无法解析的地址时返回 -1
+func (conf proxyConfig) clientIndex(ips []net.IP) int {
+	for i := len(ips) - 1; i >= 0; i-- {
+		if ips[i] == nil {
+			return -1
+		}
+		if !conf.trustedIP(ips[i]) || i == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// @brief X-Forwarded-For 中的地址
+//  @param h 请求头
+//  @return 代理依次追加的地址，无法解析的为 nil
+func forwardedForIPs(h http.Header) []net.IP {
+	var ips []net.IP
+	for _, v := range h.Values("X-Forwarded-For") {
+		for _, s := range strings.Split(v, ",") {
+			ips = append(ips, net.ParseIP(strings.TrimSpace(s)))
+		}
+	}
+	return ips
+}
+
+// @brief 从 X-Forwarded-For 中取出客户端 IP
+//  @param h 请求头
+//  @return 从右向左第一个不是可信代理的 IP，都是可信代理时返回最左边的 IP，
+//  没有或格式错误时返回 X-Real-IP，仍然没有时返回空字符串
+func (conf proxyConfig) forwardedFor(h http.Header) string {
+	ips := forwardedForIPs(h)
+	if i := conf.clientIndex(ips); i >= 0 {
+		return ips[i].String()
+	}
+	if len(ips) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(h.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// @brief 客户端 IP
+//  @param c 上下文
+//  @return 客户端 IP，在没有设为可信的 unix 套接字之后时可能为空字符串
+//  @remark 只采用可信代理传来的 X-Forwarded-For。
+func clientIP(c *gin.Context) string {
+	if ip, ok := c.Get(ctxClientIP); ok {
+		return ip.(string)
+	}
+	return c.ClientIP()
+}
+
+// @brief 解析 Forwarded 中 for 的地址
+//  @param v for 的值，可以带引号、方括号与端口
+//  @return 地址，无法解析（例如 unknown 或隐藏的标识）时返回 nil
+func forwardedNode(v string) net.IP {
+	v = strings.Trim(v, `"`)
+	if strings.HasPrefix(v, "[") {
+		v, _, _ = strings.Cut(v[1:], "]")
+	} else if strings.Count(v, ":") == 1 {
+		v, _, _ = strings.Cut(v, ":")
+	}
+	return net.ParseIP(v)
+}
+
+// @brief 可信代理传来的原始请求协议
+//  @param h 请求头
+//  @return http 或 https，没有时返回空字符串
+//  @remark 代理把自己的记录追加在最右边，左边的记录可能由客户端伪造，
+//  所以与客户端 IP 一样从右向左跳过可信代理，采用最先收到请求的可信代理记录的协议。
+//  优先使用 Forwarded，其次使用 X-Forwarded-Proto，X-Forwarded-Proto 按
+//  X-Forwarded-For 确定位置，对不上时采用最右边的记录。
+func (conf proxyConfig) forwardedProto(h http.Header) string {
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		var nodes []net.IP
+		var protos []string
+		for _, v := range values {
+			for _, elem := range strings.Split(v, ",") {
+				var node net.IP
+				proto := ""
+				for _, pair := range strings.Split(elem, ";") {
+					k, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					switch strings.ToLower(k) {
+					case "for":
+						node = forwardedNode(v)
+					case "proto":
+						proto = normalizeScheme(strings.Trim(v, `"`))
+					}
+				}
+				nodes = append(nodes, node)
+				protos = append(protos, proto)
+			}
+		}
+		if i := conf.clientIndex(nodes); i >= 0 {
+			return protos[i]
+		}
+		// 客户端的地址无法解析时只采用直接相连的代理的记录
+		return protos[len(protos)-1]
+	}
+	var protos []string
+	for _, v := range h.Values("X-Forwarded-Proto") {
+		protos = append(protos, strings.Split(v, ",")...)
+	}
+	if len(protos) == 0 {
+		return ""
+	}
+	i := len(protos) - 1
+	ips := forwardedForIPs(h)
+	if c := conf.clientIndex(ips); c >= 0 && len(protos)-len(ips)+c >= 0 {
+		i = len(protos) - len(ips) + c
+	}
+	return normalizeScheme(protos[i])
+}
+
+// @brief 只接受 http 与 https
+func normalizeScheme(s string) string {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "http", "https":
+		return s
+	}
+	return ""
+}
+
+// @brief 请求使用的协议
+//  @param c 上下文
+//  @return http 或 https
+func requestScheme(c *gin.Context) string {
+	if s := c.GetString(ctxScheme); s != "" {
+		return s
+	}
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// @brief 反向代理中间件
+//  @param conf 反向代理参数
+//  @remark 记录客户端实际使用的协议与客户端 IP，在可信代理之后时采用代理传来的值。
+//  设置了 redirect_https 时把 http 请求用 308 跳转到同一地址的 https。
+func proxy(conf proxyConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		} else if conf.trusted(c) {
+			if p := conf.forwardedProto(c.Request.Header); p != "" {
+				scheme = p
+			}
+		}
+		c.Set(ctxScheme, scheme)
+		// unix 套接字的对端没有 IP，gin 不会采用 X-Forwarded-For
+		if fromUnixSocket(c) && conf.unix {
+			c.Set(ctxClientIP, conf.forwardedFor(c.Request.Header))
+		} else {
+			c.Set(ctxClientIP, c.ClientIP())
+		}
+		if conf.RedirectHTTPS && scheme == "http" {
+			c.Abort()
+			c.Redirect(http.StatusPermanentRedirect,
+				"https://"+c.Request.Host+c.Request.URL.RequestURI())
+			return
+		}
+		c.Next()
+	}
+}
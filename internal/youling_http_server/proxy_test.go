@@ -0,0 +1,179 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试读取代理传来的协议
+func TestForwardedProto(t *testing.T) {
+	conf := proxyConfig{}
+	_, n, _ := net.ParseCIDR("10.0.0.0/8")
+	conf.nets = []*net.IPNet{n}
+	cases := []struct {
+		header map[string]string
+		want   string
+	}{
+		{map[string]string{}, ""},
+		{map[string]string{"X-Forwarded-Proto": "https"}, "https"},
+		{map[string]string{"X-Forwarded-Proto": "HTTPS"}, "https"},
+		// 客户端伪造的 https 在左边，可信代理追加的 http 在右边
+		{map[string]string{"X-Forwarded-Proto": "https, http"}, "http"},
+		{map[string]string{"X-Forwarded-Proto": "https, http",
+			"X-Forwarded-For": "203.0.113.9, 198.51.100.7"}, "http"},
+		// 右边的记录来自可信代理，采用最先收到请求的代理记录的协议
+		{map[string]string{"X-Forwarded-Proto": "https, http",
+			"X-Forwarded-For": "203.0.113.9, 10.0.0.2"}, "https"},
+		{map[string]string{"Forwarded": `for=203.0.113.9;proto="https", ` +
+			`for=198.51.100.7;proto=http`}, "http"},
+		{map[string]string{"Forwarded": `for=203.0.113.9;proto="https", ` +
+			`for="10.0.0.2:8080";proto=http`}, "https"},
+		{map[string]string{"Forwarded": "for=203.0.113.9;proto=http",
+			"X-Forwarded-Proto": "https"}, "http"},
+		{map[string]string{"X-Forwarded-Proto": "ftp"}, ""},
+	}
+	for _, c := range cases {
+		h := http.Header{}
+		for k, v := range c.header {
+			h.Set(k, v)
+		}
+		if got := conf.forwardedProto(h); got != c.want {
+			t.Errorf("%v：返回 %q，预期 %q", c.header, got, c.want)
+		}
+	}
+}
+
+// @brief 测试在反向代理之后运行
+func TestProxy(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	config := testConfig()
+	config.ServerConfig = "testdata/server_proxy.toml"
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	do := func(req *http.Request, header map[string]string) *httptest.ResponseRecorder {
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w
+	}
+	t.Run("http 请求跳转到 https", func(t *testing.T) {
+		w := do(httptest.NewRequest("GET", "http://example.com/?a=1", nil), nil)
+		if w.Code != http.StatusPermanentRedirect ||
+			w.Header().Get("Location") != "https://example.com/?a=1" {
+			t.Errorf("返回 %d %v，预期跳转到 https", w.Code, w.Header())
+		}
+	})
+	t.Run("可信代理传来的协议", func(t *testing.T) {
+		for _, h := range []map[string]string{{"X-Forwarded-Proto": "https"},
+			{"Forwarded": "for=203.0.113.9;proto=https"}} {
+			if w := do(httptest.NewRequest("GET", "/", nil), h); w.Code != http.StatusOK {
+				t.Errorf("%v：返回 %d，预期 200", h, w.Code)
+			}
+		}
+	})
+	t.Run("错误测试：不可信的对端传来的协议", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "198.51.100.7:1234"
+		w := do(req, map[string]string{"X-Forwarded-Proto": "https"})
+		if w.Code != http.StatusPermanentRedirect {
+			t.Errorf("返回 %d，预期 308", w.Code)
+		}
+	})
+	t.Run("限流使用可信代理传来的客户端 IP", func(t *testing.T) {
+		freezeRateLimiters(s)
+		login := func(ip string) int {
+			form := url.Values{"username": {"alice"}, "password": {"wrong"}}
+			req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			addCSRF(req)
+			return do(req, map[string]string{"X-Forwarded-Proto": "https",
+				"X-Forwarded-For": ip}).Code
+		}
+		for i := 0; i < 5; i++ {
+			login("203.0.113.9")
+		}
+		if code := login("203.0.113.9"); code != http.StatusTooManyRequests {
+			t.Errorf("超过限制时返回 %d，预期 429", code)
+		}
+		if code := login("203.0.113.10"); code != http.StatusUnauthorized {
+			t.Errorf("其它客户端返回 %d，预期 401", code)
+		}
+	})
+	t.Run("错误测试：可信代理地址无效", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "server.toml")
+		os.WriteFile(file, []byte("[proxy]\ntrusted_proxies = [\"10.0.0.300\"]\n"), 0600)
+		if _, err := readProxy(file); err == nil {
+			t.Errorf("地址无效，但没有报错。")
+		}
+	})
+}
+
+// @brief 测试 unix 套接字之后的代理
+func TestUnixSocketProxy(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	handler := func(conf proxyConfig) *gin.Engine {
+		router := gin.New()
+		router.SetTrustedProxies(conf.ginProxies())
+		router.Use(proxy(conf))
+		router.GET("/", func(c *gin.Context) {
+			c.String(http.StatusOK, requestScheme(c)+" "+clientIP(c)+" "+
+				rateKey(c, rateKeyIP))
+		})
+		return router
+	}
+	get := func(router *gin.Engine, header map[string]string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		// 从 unix 套接字收到的请求没有对端 IP
+		req.RemoteAddr = "@"
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey,
+			&net.UnixAddr{Name: "/run/sunflower.sock", Net: "unix"}))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+	header := map[string]string{"X-Forwarded-Proto": "https",
+		"X-Forwarded-For": "203.0.113.9, 10.0.0.2"}
+	t.Run("信任 unix 套接字的对端", func(t *testing.T) {
+		conf := proxyConfig{TrustedProxies: []string{"unix", "10.0.0.0/8"}, unix: true}
+		_, n, _ := net.ParseCIDR("10.0.0.0/8")
+		conf.nets = []*net.IPNet{n}
+		if got := get(handler(conf), header); got != "https 203.0.113.9 ip:203.0.113.9" {
+			t.Errorf("返回 %q", got)
+		}
+	})
+	t.Run("不信任 unix 套接字的对端", func(t *testing.T) {
+		if got := get(handler(proxyConfig{}), header); got != "http  " {
+			t.Errorf("返回 %q，预期不采用代理传来的值，也不按 IP 限流", got)
+		}
+	})
+	t.Run("读取 unix", func(t *testing.T) {
+		conf, err := readProxy("testdata/server_proxy.toml")
+		if err != nil || !conf.unix || len(conf.ginProxies()) != 2 {
+			t.Errorf("读取可信代理不正确：%+v %v", conf, err)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 限流时区分客户端的方式
+const (
+	rateKeyIP    = "ip"    // 按客户端 IP
+	rateKeyUser  = "user"  // 按登录的用户，没有登录时按客户端 IP
+	rateKeyToken = "token" // 按 API 令牌，没有使用令牌时按用户
+)
+
+// 清理已经装满的令牌桶的间隔
+const ratePruneInterval = time.Minute
+
+// @brief 检查路由的限流参数
+//  @param r 路由
+//  @return 参数有效时返回 nil
+func checkRateLimit(r Router) error {
+	if r.RateLimit < 0 || r.RateBurst < 0 {
+		return errors.New("路由 " + r.Path + " 的 rate_limit 或 rate_burst 无效。")
+	}
+	switch r.RateKey {
+	case "", rateKeyIP, rateKeyUser, rateKeyToken:
+	default:
+		return errors.New("路由 " + r.Path + " 的 rate_key 无效：" + r.RateKey)
+	}
+	return nil
+}
+
+// @brief 令牌桶
+type bucket struct {
+	tokens float64   // 剩余的令牌
+	last   time.Time // 上次计算令牌的时间
+}
+
+// @brief 令牌桶限流器
+//  @remark 每个客户端一个令牌桶，每个请求取走一个令牌，令牌按固定速度补充。
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // 每秒补充的令牌数
+	burst     float64 // 令牌桶的容量
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	now       func() time.Time // 取得当前时间，测试时可以替换
+}
+
+// @brief 创建限流器
+//  @param perMinute 每分钟允许的请求数
+//  @param burst 允许连续发送的请求数，为 0 时与 perMinute 相同
+func newRateLimiter(perMinute int, burst int) *rateLimiter {
+	if burst == 0 {
+		burst = perMinute
+	}
+	return &rateLimiter{rate: float64(perMinute) / 60, burst: float64(burst),
+		buckets: map[string]*bucket{}, now: time.Now}
+}
+
+// @brief 取走一个令牌
+//  @param key 客户端
+//  @param now 当前时间
+//  @return 是否允许此请求，不允许时同时返回需要等待的时间
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastPrune) >= ratePruneInterval {
+		l.prune(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// @brief 删除已经装满的令牌桶，避免占用的内存不断增长
+//  @remark 调用者需要持有锁。
+func (l *rateLimiter) prune(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastPrune = now
+}
+
+// @brief 所有路由的限流器
+//  @remark 限流器由所有监听共享，重新载入路由后参数不变的路由继续使用原来的限流器。
+type rateLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*rateLimiter
+}
+
+// @brief 取得路由的限流器
+//  @param r 路由
+//  @return 限流器，路由没有设置 rate_limit 时返回 nil
+func (ls *rateLimiters) get(r Router) *rateLimiter {
+	if r.RateLimit == 0 {
+		return nil
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.limiters == nil {
+		ls.limiters = map[string]*rateLimiter{}
+	}
+	key := r.Type + " " + routePattern(r)
+	n := newRateLimiter(r.RateLimit, r.RateBurst)
+	if l, ok := ls.limiters[key]; ok && l.rate == n.rate && l.burst == n.burst {
+		return l
+	}
+	ls.limiters[key] = n
+	return n
+}
+
+// @brief 限流时使用的客户端标识
+//  @param c 上下文
+//  @param key 区分客户端的方式
+//  @return 客户端标识，不能确定客户端时返回空字符串
+func rateKey(c *gin.Context, key string) string {
+	switch key {
+	case rateKeyToken:
+		if t, ok := c.Get(ctxToken); ok {
+			return "token:" + t.(APIToken).ID
+		}
+		fallthrough
+	case rateKeyUser:
+		if name := c.GetString(ctxUser); name != "" {
+			return "user:" + name
+		}
+	}
+	// 不能确定客户端 IP 时不按 IP 限流，避免所有客户端共用一个令牌桶
+	if ip := clientIP(c); ip != "" {
+		return "ip:" + ip
+	}
+	return ""
+}
+
+// @brief 限流中间件
+//  @param l 限流器，为 nil 时不限流
+//  @param r 路由
+//  @remark 超过限制时返回 429，并在 Retry-After 中给出需要等待的秒数。
+//  不能确定客户端（例如在没有设为可信的 unix 套接字之后）时不限流。
+func rateLimit(l *rateLimiter, r Router) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil {
+			c.Next()
+			return
+		}
+		key := rateKey(c, r.RateKey)
+		if key == "" {
+			c.Next()
+			return
+		}
+		ok, wait := l.allow(key, l.now())
+		if !ok {
+			appLog().Info("请求过于频繁", "request_id", c.GetString(ctxRequestID),
+				"path", c.Request.URL.Path, "key", key)
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			abortWithError(c, http.StatusTooManyRequests)
+			return
+		}
+		c.Next()
+	}
+}
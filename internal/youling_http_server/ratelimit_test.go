@@ -0,0 +1,122 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试令牌桶限流器
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(60, 2)
+	t.Run("连续请求", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if ok, _ := l.allow("a", now); !ok {
+				t.Fatalf("第 %d 个请求被拒绝。", i+1)
+			}
+		}
+		ok, wait := l.allow("a", now)
+		if ok || wait != time.Second {
+			t.Errorf("超过限制时返回 %v %v，预期拒绝并等待 1 秒", ok, wait)
+		}
+		if ok, _ := l.allow("b", now); !ok {
+			t.Errorf("其它客户端的请求被拒绝。")
+		}
+	})
+	t.Run("令牌按时间补充", func(t *testing.T) {
+		if ok, _ := l.allow("a", now.Add(time.Second)); !ok {
+			t.Errorf("等待 1 秒后请求仍被拒绝。")
+		}
+	})
+	t.Run("清理装满的令牌桶", func(t *testing.T) {
+		l.allow("c", now.Add(time.Hour))
+		if _, ok := l.buckets["a"]; ok || len(l.buckets) != 1 {
+			t.Errorf("没有清理装满的令牌桶：%v", l.buckets)
+		}
+	})
+	t.Run("参数不变时继续使用原来的限流器", func(t *testing.T) {
+		var ls rateLimiters
+		r := Router{Type: "function", Path: "/login", RateLimit: 10}
+		l1 := ls.get(r)
+		if l2 := ls.get(r); l1 != l2 {
+			t.Errorf("参数不变，但创建了新的限流器。")
+		}
+		r.RateLimit = 20
+		if l3 := ls.get(r); l3 == l1 {
+			t.Errorf("参数改变，但仍使用原来的限流器。")
+		}
+		if ls.get(Router{Path: "/"}) != nil {
+			t.Errorf("没有设置 rate_limit 的路由不应有限流器。")
+		}
+	})
+	t.Run("错误测试：参数无效", func(t *testing.T) {
+		if checkRateLimit(Router{Path: "/", RateLimit: -1}) == nil {
+			t.Errorf("rate_limit 无效，但没有报错。")
+		}
+		if checkRateLimit(Router{Path: "/", RateKey: "host"}) == nil {
+			t.Errorf("rate_key 无效，但没有报错。")
+		}
+	})
+}
+
+// @brief 固定所有限流器的时间，使测试结果与请求耗时无关
+func freezeRateLimiters(s *Server) {
+	now := time.Now()
+	s.limiters.mu.Lock()
+	defer s.limiters.mu.Unlock()
+	for _, l := range s.limiters.limiters {
+		l.now = func() time.Time { return now }
+	}
+}
+
+// @brief 测试路由限流
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	config := testConfig()
+	config.ServerConfig = "testdata/server_users.toml"
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	freezeRateLimiters(s)
+	login := func(forwarded string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {"alice"}, "password": {"wrong"}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		addCSRF(req)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w
+	}
+	for i := 0; i < 5; i++ {
+		if w := login(""); w.Code != http.StatusUnauthorized {
+			t.Fatalf("第 %d 次登录返回 %d，预期 401", i+1, w.Code)
+		}
+	}
+	w := login("")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("超过限制时返回 %d %v，预期 429", w.Code, w.Header())
+	}
+	t.Run("错误测试：伪造 X-Forwarded-For", func(t *testing.T) {
+		if w := login("203.0.113.9"); w.Code != http.StatusTooManyRequests {
+			t.Errorf("伪造 X-Forwarded-For 后返回 %d，预期 429", w.Code)
+		}
+	})
+}
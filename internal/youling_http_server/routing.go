@@ -13,9 +13,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/pelletier/go-toml"
 )
 
 // @brief 路由配置结构
@@ -26,60 +27,224 @@ type Router struct {
 	Template    string // 模板
 	Replacement string // 替换
 	Dir         string // 目录
+	Group       string // 路由组，为空时属于 public 组
+	// 静态文件的 Cache-Control，为空时不设置
+	CacheControl string `toml:"cache_control"`
+	Fingerprint  bool   // 是否为静态文件生成带指纹的网址
+	// 访问控制：anonymous（默认）所有人都可以访问，user 需要登录
+	Auth  string
+	Roles []string // 需要具有其中一个角色，为空时不检查角色
+	Scope string   // 使用 API 令牌访问时令牌需要具有的权限范围
+	// 覆盖服务器参数中的安全响应头，值为空时不发送此响应头
+	Headers map[string]string
+	// 限流：每分钟允许的请求数，为 0 时不限流
+	RateLimit int `toml:"rate_limit"`
+	// 允许连续发送的请求数，为 0 时与 rate_limit 相同
+	RateBurst int `toml:"rate_burst"`
+	// 区分客户端的方式：ip（默认）、user 或 token
+	RateKey string `toml:"rate_key"`
+	// 请求内容的最大字节数，为 0 时不限制
+	MaxBody int64 `toml:"max_body"`
+	// 接受的请求内容类型，为空时不检查
+	ContentTypes []string `toml:"content_types"`
+}
+
+// @brief 错误页面配置结构
+type ErrorPage struct {
+	Status      int    // 状态码
+	Function    string // 函数，对应占位符列表中的名称
+	Template    string // 模板
+	Replacement string // 替换
 }
 
 type Routers struct {
-	Routing []Router // 路由列表
+	Routing []Router    // 路由列表
+	Errors  []ErrorPage // 错误页面列表
 }
 
-// @brief 设置路由
-//  @param gin router
-//  @return 0 成功，-1 失败
-func setupRouter(router *gin.Engine) error {
+// 未指定路由组时使用的组名
+const defaultRouteGroup = "public"
+
+// @brief 站点内容：模板、路由表与占位符
+//  @remark 同一份站点内容由所有监听共享，刷新模板后所有监听同时生效。
+type site struct {
+	mu            sync.RWMutex
+	templatesList string              // 模板清单文件
+	templates     map[string]string   // 模板文件内容
+	routers       Routers             // 路由配置表
+	placeHolder   map[string][]string // 占位符列表
+	assets        *assets             // 静态文件指纹表
+}
+
+// @brief 读取站点内容
+//  @param config 配置文件位置
+//  @return 站点内容，失败时返回错误信息
+func loadSite(config Config) (*site, error) {
+	st := &site{templatesList: config.TemplatesList}
 	// 1.读取模板文件内容
 	// 通过这样的方式把模板文件内容读入内存，以减少磁盘读取
-	templates := make(map[string]string)
-	err := readTemplates("config/templates_list.toml", templates)
+	st.templates = make(map[string]string)
+	err := readTemplates(config.TemplatesList, st.templates)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// 2. 读取路由配置表
-	var router_list Routers
-	err = readRouting(&router_list)
+	err = readRouting(config.Routing, &st.routers)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// 3. 读取占位符列表
-	placeHolder := make(map[string][]string)
-	err = readPlaceHolderList("config/place_holder.toml", placeHolder)
+	st.placeHolder = make(map[string][]string)
+	err = readPlaceHolderList(config.PlaceHolder, st.placeHolder)
+	if err != nil {
+		return nil, err
+	}
+	// 4. 生成静态文件指纹，模板中的引用替换为带指纹的网址
+	st.assets, err = buildAssets(st.routers.Routing)
+	if err != nil {
+		return nil, err
+	}
+	st.assets.rewrite(st.templates)
+	return st, nil
+}
+
+// @brief 生成页面
+//  @param r 路由
+//  @return 页面内容
+func (st *site) render(r Router) string {
+	start := time.Now()
+	st.mu.RLock()
+	page := replacePlaceHolder(r, st.placeHolder[r.Function],
+		st.templates[r.Template], st.templates[r.Replacement])
+	st.mu.RUnlock()
+	appMetrics.observeRender(r, time.Since(start))
+	return page
+}
+
+// @brief 刷新模板文件
+//  @return 成功：nil，失败：错误信息
+//  @remark 静态文件的指纹同时重新生成。
+func (st *site) refreshTemplates() error {
+	templates := make(map[string]string)
+	err := refreshTemplates(st.templatesList, templates)
+	var a *assets
+	if err == nil {
+		a, err = buildAssets(st.routers.Routing)
+	}
+	appMetrics.observeReload(err)
 	if err != nil {
 		return err
 	}
-	// 4. 设置路由
+	a.rewrite(templates)
+	st.mu.Lock()
+	st.templates = templates
+	st.assets = a
+	st.mu.Unlock()
+	return nil
+}
+
+// @brief 取得当前的静态文件指纹表
+func (st *site) currentAssets() *assets {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.assets
+}
+
+// @brief 路由组是否在列表中
+//  @param group 路由组
+//  @param groups 路由组列表，为空时表示所有路由组
+func inGroups(group string, groups []string) bool {
+	if len(groups) == 0 {
+		return true
+	}
+	if group == "" {
+		group = defaultRouteGroup
+	}
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// @brief 设置路由
+//  @param gin router
+//  @param s http 服务
+//  @param st 站点内容
+//  @param groups 需要设置的路由组，为空时设置所有路由组
+//  @return 0 成功，-1 失败
+func setupRouter(router *gin.Engine, s *Server, st *site,
+	groups []string) error {
+	// 已经设置了预检请求的路径，同一路径只设置一次
+	preflights := map[string]bool{}
 	// 按照路由配置表设置路由
 	// 这里不能直接调用多参数的函数，
 	// 需要使用func(c *gin.Context)作为中转来调用多参数的函数
-	for _, r := range router_list.Routing {
+	for _, r := range st.routers.Routing {
 		r1 := r // 这里不能直接把 r 交给下面去处理，否则传过去的 r 始终会指向最后一项
+		if !inGroups(r1.Group, groups) {
+			continue
+		}
+		if err := checkAuth(r1); err != nil {
+			return err
+		}
+		if err := checkHeaders(r1); err != nil {
+			return err
+		}
+		if err := checkRateLimit(r1); err != nil {
+			return err
+		}
+		if err := checkBody(r1); err != nil {
+			return err
+		}
+		// 允许跨域访问的路由组需要处理预检请求
+		if p := s.cors.policy(r1.Group); p.Enabled && !preflights[routePattern(r1)] {
+			preflights[routePattern(r1)] = true
+			router.OPTIONS(routePattern(r1), routeMetrics(r1), preflight(p))
+		}
+		// 每个路由都有自己的中间件，例如按 routing.toml 中的 path 与 type 统计指标
+		g := router.Group("", routeMiddleware(s, st, r1)...)
 		switch r1.Type {
 		case "static":
-			router.Static(r1.Path, r1.Dir)
+			g.GET(routePattern(r1), serveStatic(r1, st))
+			g.HEAD(routePattern(r1), serveStatic(r1, st))
 		case "template":
-			router.GET(r1.Path, func(c *gin.Context) {
-				str := replacePlaceHolder(r1, placeHolder[r1.Function],
-					templates[r1.Template], templates[r1.Replacement])
-				c.Writer.Write([]byte(str))
+			g.GET(r1.Path, func(c *gin.Context) {
+				page := replaceRequestPlaceHolder(st.render(r1),
+					requestPlaceHolders(c))
+				c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
 			})
 		case "function":
 			switch r1.Function {
 			case "refreshTemplates":
-				router.GET(r1.Path, func(c *gin.Context) {
-					refreshTemplates(templates)
+				g.GET(r1.Path, func(c *gin.Context) {
+					st.refreshTemplates()
 				})
 			case "handleData":
-				router.POST(r1.Path, func(c *gin.Context) {
-					handleData(c)
+				g.POST(r1.Path, func(c *gin.Context) {
+					if err := handleData(c); err != nil {
+						appLog().Error("处理数据失败", "request_id",
+							c.GetString(ctxRequestID), "error", err)
+						abortWithError(c, bodyErrorStatus(err))
+					}
 				})
+			case "login":
+				g.POST(r1.Path, s.handleLogin(st, r1))
+			case "logout":
+				g.POST(r1.Path, s.handleLogout)
+			case "listTokens":
+				g.GET(r1.Path, handleListTokens(s.tokens))
+			case "createToken":
+				g.POST(r1.Path, handleCreateToken(s.tokens))
+			case "revokeToken":
+				g.DELETE(routePattern(r1), handleRevokeToken(s.tokens))
+			case "metrics":
+				g.GET(r1.Path, handleMetrics)
+			case "healthz":
+				g.GET(r1.Path, handleHealthz)
+			case "readyz":
+				g.GET(r1.Path, handleReadyz(s.readinessChecks(st)))
 			default:
 				return errors.New(fmt.Sprintf("未知路由功能：%s", r1.Function))
 			}
@@ -95,35 +260,42 @@ func setupRouter(router *gin.Engine) error {
 func handleData(c *gin.Context) error {
 	// 获取来自网页提交的内容
 	str, err := ioutil.ReadAll(c.Request.Body)
+	if err == errBodyTooLarge {
+		return err
+	}
 	if err != nil {
 		return errors.New("读取数据时发生错误：" + err.Error())
 	}
-	fmt.Println(string(str))
+	appLog().Info("收到数据", "request_id", c.GetString(ctxRequestID),
+		"data", string(str))
 	c.String(http.StatusCreated, string(str))
 	return nil
 }
 
 // @brief 读取路由配置表
-func readRouting(r *Routers) error {
-	conf, err := toml.LoadFile("config/routing.toml")
+//  @param file 路由配置文件
+//  @param r 路由列表
+func readRouting(file string, r *Routers) error {
+	conf, err := loadToml(file)
 	if err != nil {
-		return errors.New("载入 routing.toml 时发生错误：" + err.Error())
+		return errors.New("载入 " + file + " 时发生错误：" + err.Error())
 	}
 	err = conf.Unmarshal(r)
 	if err != nil {
-		return errors.New("解析 routing.toml 时发生错误：" + err.Error())
+		return errors.New("解析 " + file + " 时发生错误：" + err.Error())
 	}
 	return nil
 }
 
 // @brief 刷新模板文件
+//  @param file 模板清单文件
 //  @param tpl 模板文件哈希表
 //  @return 成功：nil，失败：错误信息
-func refreshTemplates(tpl map[string]string) error {
-	err := readTemplates("config/templates_list.toml", tpl)
+func refreshTemplates(file string, tpl map[string]string) error {
+	err := readTemplates(file, tpl)
 	if err != nil {
 		return err
 	}
-	fmt.Println("\n刷新模板文件完成……")
+	appLog().Info("刷新模板文件完成。", "file", file)
 	return nil
 }
@@ -0,0 +1,174 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml"
+)
+
+// 安全响应头
+const (
+	headerCSP            = "Content-Security-Policy"
+	headerContentType    = "X-Content-Type-Options"
+	headerFrameOptions   = "X-Frame-Options"
+	headerReferrerPolicy = "Referrer-Policy"
+	headerHSTS           = "Strict-Transport-Security"
+)
+
+// CSP nonce 在 gin.Context 中的键名
+const ctxCSPNonce = "csp_nonce"
+
+// CSP 中代表本次请求 nonce 的占位符
+const cspNoncePlaceHolder = "{nonce}"
+
+// @brief 安全响应头参数
+type securityConfig struct {
+	Enabled bool `toml:"enabled"` // 是否发送安全响应头
+	// Content-Security-Policy，为空时不发送，其中的 {nonce} 替换为本次请求的 nonce
+	ContentSecurityPolicy string `toml:"content_security_policy"`
+	NoSniff               bool   `toml:"no_sniff"`        // 是否发送 X-Content-Type-Options: nosniff
+	FrameOptions          string `toml:"frame_options"`   // X-Frame-Options，为空时不发送
+	ReferrerPolicy        string `toml:"referrer_policy"` // Referrer-Policy，为空时不发送
+	// Strict-Transport-Security 的 max-age（秒），为 0 时不发送，只在 HTTPS 请求中发送
+	HSTSMaxAge            int  `toml:"hsts_max_age"`
+	HSTSIncludeSubdomains bool `toml:"hsts_include_subdomains"` // HSTS 是否包括子域名
+	HSTSPreload           bool `toml:"hsts_preload"`            // HSTS 是否加入 preload
+}
+
+// @brief 读取安全响应头参数
+//  @param file 服务器参数文件
+//  @return 安全响应头参数，失败时返回错误信息
+func readSecurity(file string) (securityConfig, error) {
+	conf := securityConfig{Enabled: true, NoSniff: true, FrameOptions: "SAMEORIGIN",
+		ReferrerPolicy: "strict-origin-when-cross-origin"}
+	config, err := loadToml(file)
+	if err != nil {
+		return conf, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	if !config.Has("security_headers") {
+		return conf, nil
+	}
+	if err := config.Get("security_headers").(*toml.Tree).Unmarshal(&conf); err != nil {
+		return conf, errors.New("解析服务器参数文件 " + file +
+			" 中的 security_headers 时发生错误：" + err.Error())
+	}
+	if conf.HSTSMaxAge < 0 {
+		return conf, errors.New("服务器参数文件 " + file + " 中 hsts_max_age 字段无效。")
+	}
+	return conf, nil
+}
+
+// @brief 生成 Strict-Transport-Security 的值
+//  @return 响应头的值，max-age 为 0 时返回空字符串
+func (conf securityConfig) hsts() string {
+	if conf.HSTSMaxAge == 0 {
+		return ""
+	}
+	v := "max-age=" + strconv.Itoa(conf.HSTSMaxAge)
+	if conf.HSTSIncludeSubdomains {
+		v += "; includeSubDomains"
+	}
+	if conf.HSTSPreload {
+		v += "; preload"
+	}
+	return v
+}
+
+// @brief 检查路由的安全响应头参数
+//  @param r 路由
+//  @return 参数有效时返回 nil
+//  @remark 路由只能覆盖安全响应头，不能设置其它响应头。
+func checkHeaders(r Router) error {
+	for k := range r.Headers {
+		switch http.CanonicalHeaderKey(k) {
+		case headerCSP, headerContentType, headerFrameOptions, headerReferrerPolicy,
+			headerHSTS:
+		default:
+			return errors.New("路由 " + r.Path + " 的 headers 中不能设置 " + k + "。")
+		}
+	}
+	return nil
+}
+
+// @brief 生成新的 CSP nonce
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// @brief 是否为 HTTPS 请求
+//  @remark 在可信代理之后时按代理传来的协议判断。
+func isHTTPS(c *gin.Context) bool {
+	return requestScheme(c) == "https"
+}
+
+// @brief 设置一个安全响应头
+//  @param c 上下文
+//  @param name 响应头名称
+//  @param value 响应头的值，为空时删除此响应头
+//  @remark CSP 中的 {nonce} 替换为本次请求的 nonce，HSTS 只在 HTTPS 请求中发送。
+func setSecurityHeader(c *gin.Context, name string, value string) {
+	name = http.CanonicalHeaderKey(name)
+	if name == headerHSTS && !isHTTPS(c) {
+		value = ""
+	}
+	if name == headerCSP {
+		value = strings.Replace(value, cspNoncePlaceHolder, c.GetString(ctxCSPNonce), -1)
+	}
+	if value == "" {
+		c.Writer.Header().Del(name)
+		return
+	}
+	c.Header(name, value)
+}
+
+// @brief 安全响应头中间件
+//  @param conf 安全响应头参数
+//  @remark 每个请求生成一个 nonce，模板中可以用 <!--{{.csp_nonce}}--> 取得，
+//  例如 <script nonce="<!--{{.csp_nonce}}-->">。
+func securityHeaders(conf securityConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !conf.Enabled {
+			c.Next()
+			return
+		}
+		c.Set(ctxCSPNonce, newCSPNonce())
+		setSecurityHeader(c, headerCSP, conf.ContentSecurityPolicy)
+		if conf.NoSniff {
+			setSecurityHeader(c, headerContentType, "nosniff")
+		}
+		setSecurityHeader(c, headerFrameOptions, conf.FrameOptions)
+		setSecurityHeader(c, headerReferrerPolicy, conf.ReferrerPolicy)
+		setSecurityHeader(c, headerHSTS, conf.hsts())
+		c.Next()
+	}
+}
+
+// @brief 路由自己的安全响应头
+//  @param r 路由，headers 中的响应头覆盖服务器参数中的设置，值为空时不发送
+//  @remark 服务器参数中关闭了安全响应头时不起作用。
+func routeHeaders(r Router) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(ctxCSPNonce); ok {
+			for k, v := range r.Headers {
+				setSecurityHeader(c, k, v)
+			}
+		}
+		c.Next()
+	}
+}
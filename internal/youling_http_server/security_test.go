@@ -0,0 +1,101 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试安全响应头
+func TestSecurityHeaders(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	config := testConfig()
+	config.ServerConfig = "testdata/server_users.toml"
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	get := func(path string, https bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if https {
+			req.TLS = &tls.ConnectionState{}
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w
+	}
+	t.Run("默认的安全响应头", func(t *testing.T) {
+		h := get("/", false).Header()
+		if h.Get("X-Content-Type-Options") != "nosniff" ||
+			h.Get("X-Frame-Options") != "SAMEORIGIN" ||
+			h.Get("Referrer-Policy") != "strict-origin-when-cross-origin" {
+			t.Errorf("安全响应头不正确：%v", h)
+		}
+		if h.Get("Strict-Transport-Security") != "" {
+			t.Errorf("http 请求中不应发送 HSTS：%v", h)
+		}
+	})
+	t.Run("HTTPS 请求发送 HSTS", func(t *testing.T) {
+		h := get("/", true).Header()
+		if h.Get("Strict-Transport-Security") != "max-age=31536000; includeSubDomains" {
+			t.Errorf("HSTS 不正确：%v", h)
+		}
+	})
+	t.Run("CSP nonce 与页面中的一致", func(t *testing.T) {
+		w := get("/login", false)
+		csp := w.Header().Get("Content-Security-Policy")
+		i := strings.Index(csp, "'nonce-")
+		if i < 0 {
+			t.Fatalf("CSP 中没有 nonce：%s", csp)
+		}
+		nonce := strings.TrimSuffix(csp[i+len("'nonce-"):], "'")
+		if !strings.Contains(w.Body.String(), `nonce="`+nonce+`"`) {
+			t.Errorf("页面中没有 nonce %s：%s", nonce, w.Body.String())
+		}
+		if other := get("/login", false).Header().Get("Content-Security-Policy"); other == csp {
+			t.Errorf("每个请求的 nonce 应该不同。")
+		}
+	})
+	t.Run("路由覆盖安全响应头", func(t *testing.T) {
+		h := get("/login", false).Header()
+		if h.Get("X-Frame-Options") != "DENY" {
+			t.Errorf("X-Frame-Options 没有被覆盖：%v", h)
+		}
+		if _, ok := h["Referrer-Policy"]; ok {
+			t.Errorf("值为空的响应头应该不发送：%v", h)
+		}
+	})
+	t.Run("错误测试：路由设置其它响应头", func(t *testing.T) {
+		r := Router{Path: "/", Headers: map[string]string{"Set-Cookie": "a=b"}}
+		if checkHeaders(r) == nil {
+			t.Errorf("设置了其它响应头，但没有报错。")
+		}
+		r.Headers = map[string]string{"x-frame-options": "DENY"}
+		if err := checkHeaders(r); err != nil {
+			t.Errorf("设置安全响应头时报错：%v", err)
+		}
+	})
+	t.Run("关闭安全响应头", func(t *testing.T) {
+		router := gin.New()
+		router.Use(securityHeaders(securityConfig{}))
+		router.GET("/", routeHeaders(Router{Headers: map[string]string{
+			"X-Frame-Options": "DENY"}}), func(c *gin.Context) {})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Header().Get("X-Frame-Options") != "" ||
+			w.Header().Get("X-Content-Type-Options") != "" {
+			t.Errorf("关闭后仍发送了安全响应头：%v", w.Header())
+		}
+	})
+}
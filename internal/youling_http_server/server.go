@@ -10,63 +10,475 @@ package youling_http_server
 
 import (
 	"context"
-	"log"
+	"errors"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml"
 )
 
+// @brief 配置文件位置
+type Config struct {
+	ServerConfig  string // 服务器参数文件
+	Routing       string // 路由配置表
+	TemplatesList string // 模板清单
+	PlaceHolder   string // 占位符列表
+}
+
+// @brief 默认的配置文件位置
+func DefaultConfig() Config {
+	return Config{
+		ServerConfig:  "config/server_config.toml",
+		Routing:       "config/routing.toml",
+		TemplatesList: "config/templates_list.toml",
+		PlaceHolder:   "config/place_holder.toml",
+	}
+}
+
+// @brief 一个监听及其对应的 http 服务器
+type endpoint struct {
+	Listener
+	srv     *http.Server
+	handler *reloadableHandler // 只包含此监听所处理路由组的路由
+	ln      net.Listener       // 已打开的监听，平滑重启时交给新进程
+}
+
+// @brief http 服务
+type Server struct {
+	config          Config
+	endpoints       []*endpoint
+	handler         *reloadableHandler // 包含所有路由组的路由处理器
+	certs           *certReloader      // 证书热加载器，未启用 TLS 时为 nil
+	certInterval    time.Duration      // 检查证书文件的间隔
+	mu              sync.Mutex
+	shutdownTimeout time.Duration     // 关闭服务时等待未完成请求的时间
+	shutdownDelay   time.Duration     // 就绪检查失败后到停止接受请求之间的等待时间
+	drain           int32             // 不为 0 时表示正在关闭服务
+	crashDir        string            // 崩溃报告目录，为空时不写崩溃报告
+	compress        compressConfig    // 压缩参数
+	users           UserStore         // 用户存储
+	sessions        *sessionManager   // 会话管理
+	tokens          TokenStore        // API 令牌存储
+	csrf            csrfConfig        // CSRF 防护参数
+	security        securityConfig    // 安全响应头参数
+	cors            corsConfig        // 跨域访问参数
+	limiters        rateLimiters      // 各路由的限流器
+	proxy           proxyConfig       // 反向代理参数
+	restart         map[string]string // 上次读取的需要重启服务才能生效的参数，用于重新载入时比较
+	stop            chan struct{}     // 关闭后停止后台任务
+	stopOnce        sync.Once
+}
+
+// @brief 创建 http 服务
+//  @param config 配置文件位置
+//  @return http 服务，失败时返回错误信息
+func New(config Config) (*Server, error) {
+	s := &Server{config: config, stop: make(chan struct{})}
+	// 1. 设置日志，访问日志与程序日志都写到这里
+	l, err := readLogger(config.ServerConfig)
+	if err != nil {
+		return nil, err
+	}
+	setAppLog(l)
+	s.restart = readRestartSections(config.ServerConfig)
+	if err := s.readSettings(); err != nil {
+		return nil, err
+	}
+	s.users, err = readUserStore(config.ServerConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.sessions, err = readSessions(config.ServerConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.tokens, err = readTokenStore(config.ServerConfig)
+	if err != nil {
+		return nil, err
+	}
+	// 2. 读取站点内容并设置路由
+	st, err := loadSite(config)
+	if err != nil {
+		return nil, err
+	}
+	// 路由放在可替换的处理器中，收到 SIGHUP 时可以整体重新载入
+	router, err := s.newRouter(st, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.handler = &reloadableHandler{handler: router}
+	// 3. 设置 TLS 参数
+	s.certs, s.certInterval, err = setTLS(config.ServerConfig)
+	if err != nil {
+		return nil, err
+	}
+	// 4. 按监听列表设置服务器参数，每个监听只处理自己的路由组
+	listeners, err := readListeners(config.ServerConfig)
+	if err != nil {
+		return nil, err
+	}
+	h2conf, err := readHTTP2(config.ServerConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range listeners {
+		if l.Network == "unix" && !s.proxy.unix {
+			appLog().Info("unix 套接字监听的对端不是可信代理，无法取得客户端 IP，"+
+				"按 IP 限流的路由不限流。可以在 proxy.trusted_proxies 中加入 unix。",
+				"address", l.Address)
+		}
+		router, err := s.newRouter(st, l.Groups)
+		if err != nil {
+			return nil, err
+		}
+		ep := &endpoint{Listener: l, srv: &http.Server{},
+			handler: &reloadableHandler{handler: router}}
+		err = setServer(config.ServerConfig, ep.srv, ep.handler)
+		if err != nil {
+			return nil, err
+		}
+		ep.srv.Addr = l.Address
+		// unix 套接字一般在本机反向代理之后，只有 tcp 监听使用 TLS
+		if s.certs != nil && l.Network == "tcp" {
+			ep.srv.TLSConfig = s.certs.tlsConfig()
+		}
+		if err := configureHTTP2(ep.srv, h2conf); err != nil {
+			return nil, err
+		}
+		s.endpoints = append(s.endpoints, ep)
+	}
+	s.shutdownTimeout, err = readShutdownTimeout(config.ServerConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.shutdownDelay, err = readShutdownDelay(config.ServerConfig)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// @brief 返回处理所有路由组的 http 处理器，可直接用于测试或嵌入其它服务
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// @brief 开始监听并处理请求
+//  @param ctx 取消后开始关闭服务
+//  @return 正常关闭：nil，监听失败：错误信息
+//  @remark 此函数会一直阻塞到服务关闭。所有监听一起启动、一起关闭，
+//  ctx 取消或任何一个监听出错时按配置的等待时间关闭服务。
+func (s *Server) Start(ctx context.Context) error {
+	// 1. 打开所有监听，任何一个失败都关闭已经打开的监听
+	// 平滑重启时优先使用从旧进程继承的监听
+	lns := make([]net.Listener, 0, len(s.endpoints))
+	for _, ep := range s.endpoints {
+		ln, err := inheritedListener(ep.Listener)
+		if err == nil && ln == nil {
+			ln, err = listen(ep.Listener)
+		}
+		if err != nil {
+			closeInherited()
+			for _, l := range lns {
+				l.Close()
+			}
+			return err
+		}
+		lns = append(lns, ln)
+	}
+	closeInherited()
+	s.mu.Lock()
+	for i, ep := range s.endpoints {
+		ep.ln = lns[i]
+	}
+	s.mu.Unlock()
+	// 2. 启用 TLS 时定期检查证书文件是否更新
+	if s.certs != nil && s.certInterval > 0 {
+		go s.certs.watch(s.certInterval, s.stop)
+	}
+	// 定期删除过期的会话
+	go s.sessions.prune(sessionPruneInterval, s.stop)
+	// 3. 处理请求
+	// 声明一个匿名函数，并创建一个goroutine（有的翻译为协程）
+	serveErr := make(chan error, len(s.endpoints))
+	for i, ep := range s.endpoints {
+		go func(ep *endpoint, ln net.Listener) {
+			if ep.srv.TLSConfig != nil {
+				// 证书由 TLSConfig.GetCertificate 提供，这里不需要再指定文件
+				serveErr <- ep.srv.ServeTLS(ln, "", "")
+			} else {
+				serveErr <- ep.srv.Serve(ln)
+			}
+		}(ep, lns[i])
+	}
+	// 由旧进程启动时，通知旧进程可以开始关闭
+	notifyReady()
+	// 4. 等待关闭
+	var err error
+	select {
+	case err = <-serveErr:
+		if err == http.ErrServerClosed {
+			err = nil
+		}
+	case <-ctx.Done():
+		// 先让就绪检查失败，等负载均衡不再转发新请求后再停止接受请求
+		s.startDraining()
+		s.mu.Lock()
+		delay := s.shutdownDelay
+		s.mu.Unlock()
+		time.Sleep(delay)
+	}
+	s.mu.Lock()
+	timeout := s.shutdownTimeout
+	s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if e := s.Shutdown(ctx); err == nil {
+		err = e
+	}
+	return err
+}
+
+// @brief 关闭服务
+//  @param ctx 超时或取消后不再等待未完成的请求
+//  @return 成功：nil，失败：错误信息
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.startDraining()
+	s.stopOnce.Do(func() { close(s.stop) })
+	var err error
+	for _, ep := range s.endpoints {
+		if e := ep.srv.Shutdown(ctx); e != nil && err == nil {
+			err = errors.New("http server " + ep.Address + " 关闭时出错：" +
+				e.Error())
+		}
+	}
+	return err
+}
+
+// 需要重启服务才能生效的服务器参数
+var restartSections = []string{"server", "listeners", "log", "users", "session", "tokens"}
+
+// @brief 读取需要重启服务才能生效的服务器参数
+//  @param file 服务器参数文件
+//  @return 每个参数表的内容，读取失败时返回 nil
+func readRestartSections(file string) map[string]string {
+	config, err := loadToml(file)
+	if err != nil {
+		return nil
+	}
+	sections := map[string]string{}
+	for _, name := range restartSections {
+		switch v := config.Get(name).(type) {
+		case *toml.Tree:
+			sections[name] = v.String()
+		case []*toml.Tree:
+			for _, t := range v {
+				sections[name] += t.String() + "\n"
+			}
+		}
+	}
+	return sections
+}
+
+// @brief 读取路由中间件使用的服务器参数
+//  @return 失败时返回错误信息，此时不修改原来的参数
+//  @remark 包括崩溃报告目录、压缩、CSRF、安全响应头、跨域访问与反向代理参数。
+func (s *Server) readSettings() error {
+	file := s.config.ServerConfig
+	crashDir, err := readCrashDir(file)
+	if err != nil {
+		return err
+	}
+	compress, err := readCompress(file)
+	if err != nil {
+		return err
+	}
+	csrf, err := readCSRF(file)
+	if err != nil {
+		return err
+	}
+	security, err := readSecurity(file)
+	if err != nil {
+		return err
+	}
+	cors, err := readCORS(file)
+	if err != nil {
+		return err
+	}
+	proxy, err := readProxy(file)
+	if err != nil {
+		return err
+	}
+	s.crashDir, s.compress, s.csrf = crashDir, compress, csrf
+	s.security, s.cors, s.proxy = security, cors, proxy
+	return nil
+}
+
+// @brief 重新载入配置
+//  @return 成功：nil，失败：错误信息
+//  @remark 路由表、模板、占位符，以及 compression、csrf、security_headers、cors、proxy
+//  与崩溃报告目录都会重新读取，任何一项失败都保留原来的配置。
+//  server、listeners、log、users、session 与 tokens 需要重启服务才能生效，
+//  这些参数改变时记录一条日志。
+func (s *Server) Reload() error {
+	err := s.reload()
+	appMetrics.observeReload(err)
+	if err != nil {
+		return errors.New("重新载入配置失败，继续使用原来的配置：" + err.Error())
+	}
+	if timeout, err := readShutdownTimeout(s.config.ServerConfig); err == nil {
+		s.mu.Lock()
+		s.shutdownTimeout = timeout
+		s.mu.Unlock()
+	}
+	if delay, err := readShutdownDelay(s.config.ServerConfig); err == nil {
+		s.mu.Lock()
+		s.shutdownDelay = delay
+		s.mu.Unlock()
+	}
+	// 同一处改变只提示一次
+	if restart := readRestartSections(s.config.ServerConfig); restart != nil {
+		s.mu.Lock()
+		for _, name := range restartSections {
+			if s.restart != nil && restart[name] != s.restart[name] {
+				appLog().Info("服务器参数已改变，需要重启服务才能生效", "section", name)
+			}
+		}
+		s.restart = restart
+		s.mu.Unlock()
+	}
+	if s.certs != nil {
+		if err := s.certs.reload(); err != nil {
+			return errors.New("配置已重新载入，但重新载入证书失败，继续使用旧证书：" +
+				err.Error())
+		}
+	}
+	return nil
+}
+
+// @brief 重新读取参数并替换所有路由
+//  @return 失败时返回错误信息，此时继续使用原来的参数与路由
+func (s *Server) reload() error {
+	st, err := loadSite(s.config)
+	if err != nil {
+		return err
+	}
+	crashDir, compress, csrf := s.crashDir, s.compress, s.csrf
+	security, cors, proxy := s.security, s.cors, s.proxy
+	restore := func() {
+		s.crashDir, s.compress, s.csrf = crashDir, compress, csrf
+		s.security, s.cors, s.proxy = security, cors, proxy
+	}
+	if err := s.readSettings(); err != nil {
+		return err
+	}
+	// 先建好所有路由再整体替换，避免各监听使用不同版本的配置
+	all, err := s.newRouter(st, nil)
+	if err != nil {
+		restore()
+		return err
+	}
+	routers := make([]*gin.Engine, len(s.endpoints))
+	for i, ep := range s.endpoints {
+		routers[i], err = s.newRouter(st, ep.Groups)
+		if err != nil {
+			restore()
+			return err
+		}
+	}
+	s.handler.set(all)
+	for i, ep := range s.endpoints {
+		ep.handler.set(routers[i])
+	}
+	return nil
+}
+
 // @brief 创建http server
 func CreateHttpServer() {
 	// 1. 设置运行模式
 	gin.SetMode(gin.ReleaseMode)
-	router := gin.Default()
-	// 2. 设置路由
-	err := setupRouter(router)
+	// 2. 创建服务
+	s, err := New(DefaultConfig())
 	if err != nil {
-		log.Fatalln(err)
-		return
+		appLog().Error("创建 http server 失败", "error", err)
+		os.Exit(1)
 	}
-	srv := &http.Server{}
-	// 3. 设置服务器参数
-	if setServer("config/server_config.toml", srv, router) != nil {
-		return
+	// 3. 监听请求，收到关闭信号后关闭服务
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go closeHttpServer(s, cancel)
+	if err := s.Start(ctx); err != nil {
+		appLog().Error("http server 异常退出", "error", err)
+		os.Exit(1)
 	}
-	// 4. 监听请求
-	// 声明一个匿名函数，并创建一个goroutine（有的翻译为协程）
-	go func() {
-		err := srv.ListenAndServe()
-		if err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen: %s\n", err)
-		}
-	}()
-	// 5. 关闭服务
-	closeHttpServer(srv)
+	appLog().Info("http server 退出。")
 	return
 }
 
-// @brief 关闭服务
-//  @param srv http服务器
-func closeHttpServer(srv *http.Server) {
+// @brief 创建路由
+//  @param st 站点内容
+//  @param groups 需要设置的路由组，为空时设置所有路由组
+//  @return 设置好路由的 gin 引擎，失败时返回错误信息
+func (s *Server) newRouter(st *site, groups []string) (*gin.Engine,
+	error) {
+	router := gin.New()
+	// 只采用可信代理传来的 X-Forwarded-For，没有设置时客户端 IP 只取连接的对端地址，
+	// 避免伪造客户端 IP 绕过限流或污染日志
+	if err := router.SetTrustedProxies(s.proxy.ginProxies()); err != nil {
+		return nil, errors.New("设置可信代理失败：" + err.Error())
+	}
+	router.Use(requestID(), accessLog(), proxy(s.proxy), securityHeaders(s.security),
+		withSite(st), recovery(s.crashDir), compress(s.compress),
+		s.sessions.middleware(), bearerAuth(s.tokens), csrf(s.csrf))
+	// 找不到路由的请求统一记在 unmatched 下，避免路径标签无限增长
+	router.NoRoute(routeMetrics(Router{Path: "unmatched", Type: "none"}),
+		func(c *gin.Context) {
+			abortWithError(c, http.StatusNotFound)
+		})
+	if err := setupRouter(router, s, st, groups); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+// @brief 处理关闭服务与重新载入配置的信号
+//  @param s http服务
+//  @param shutdown 调用后开始关闭服务
+func closeHttpServer(s *Server, shutdown context.CancelFunc) {
 	// 1. 创建通道，用来接收信号
 	quit := make(chan os.Signal, 1)
 	// 2. 监听和捕获信号
-	signal.Notify(quit, os.Interrupt)
-	<-quit
-	log.Println("\n>> 开始关闭 http server……")
-	// 3. 创建一个子节点的context,5秒后自动超时
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("\n>> http server 关闭时出错：", err)
-	}
-	select {
-	case <-ctx.Done():
-	default:
+	// SIGINT、SIGTERM 开始关闭服务，SIGHUP 重新载入配置与模板，
+	// SIGUSR2 把监听交给新进程后关闭服务（平滑重启）
+	signal.Notify(quit, append([]os.Signal{os.Interrupt, syscall.SIGTERM,
+		syscall.SIGHUP}, restartSignals...)...)
+	defer signal.Stop(quit)
+	for sig := range quit {
+		switch sig {
+		case syscall.SIGHUP:
+			if err := s.Reload(); err != nil {
+				appLog().Error("重新载入配置失败", "error", err)
+			} else {
+				appLog().Info("配置与模板已重新载入。")
+			}
+			continue
+		case os.Interrupt, syscall.SIGTERM:
+			appLog().Info("开始关闭 http server……", "signal", sig)
+		default:
+			if err := s.Restart(); err != nil {
+				appLog().Error("平滑重启失败，继续处理请求。", "error", err)
+				continue
+			}
+			appLog().Info("新进程已接管监听，开始关闭 http server……")
+		}
+		// 3. 通知服务关闭，等待时间由 ShutdownTimeout 决定
+		shutdown()
+		return
 	}
-	log.Println("\n>> http server 退出。")
-	return
 }
@@ -0,0 +1,225 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试用的配置文件位置
+func testConfig() Config {
+	return Config{
+		ServerConfig:  "testdata/server_local.toml",
+		Routing:       "testdata/routing.toml",
+		TemplatesList: "testdata/tpl1.toml",
+		PlaceHolder:   "testdata/place_holder.toml",
+	}
+}
+
+// @brief 测试 New 函数与 Handler 方法
+func TestNew(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	t.Run("正常测试", func(t *testing.T) {
+		s, err := New(testConfig())
+		if err != nil {
+			t.Fatalf("创建服务失败：%v", err)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("首页返回状态码 %d", w.Code)
+		}
+		w = httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/submit-data", strings.NewReader("test"))
+		req.Header.Set("Content-Type", "application/json")
+		addCSRF(req)
+		s.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusCreated || w.Body.String() != "test" {
+			t.Errorf("提交数据返回 %d：%s", w.Code, w.Body.String())
+		}
+	})
+	t.Run("错误测试：路由配置文件名错误", func(t *testing.T) {
+		config := testConfig()
+		config.Routing = "testdata/routing_error.toml"
+		if _, err := New(config); err == nil {
+			t.Errorf("路由配置文件名不正确，但没有报错。")
+		}
+	})
+}
+
+// @brief 测试 Reload 方法重新读取服务器参数
+func TestReload(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	base, err := os.ReadFile("testdata/server_local.toml")
+	if err != nil {
+		t.Fatalf("读取服务器参数文件失败：%v", err)
+	}
+	config := testConfig()
+	config.ServerConfig = filepath.Join(t.TempDir(), "server.toml")
+	write := func(extra string) {
+		if err := os.WriteFile(config.ServerConfig, append(base, extra...), 0600); err != nil {
+			t.Fatalf("写入服务器参数文件失败：%v", err)
+		}
+	}
+	write("")
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	frameOptions := func() string {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		return w.Header().Get(headerFrameOptions)
+	}
+	t.Run("重新载入安全响应头", func(t *testing.T) {
+		write("[security_headers]\nframe_options = \"DENY\"\n")
+		if err := s.Reload(); err != nil {
+			t.Fatalf("重新载入失败：%v", err)
+		}
+		if v := frameOptions(); v != "DENY" {
+			t.Errorf("X-Frame-Options 为 %q，预期 DENY", v)
+		}
+	})
+	t.Run("错误测试：参数无效时保留原来的配置", func(t *testing.T) {
+		write("[security_headers]\nframe_options = \"SAMEORIGIN\"\n" +
+			"[cors]\nmax_age = -1\n")
+		if s.Reload() == nil {
+			t.Errorf("cors 参数无效，但没有报错。")
+		}
+		if v := frameOptions(); v != "DENY" {
+			t.Errorf("X-Frame-Options 为 %q，预期保留 DENY", v)
+		}
+		if s.security.FrameOptions != "DENY" {
+			t.Errorf("重新载入失败后参数被修改：%q", s.security.FrameOptions)
+		}
+	})
+	t.Run("需要重启的参数改变时只提示一次", func(t *testing.T) {
+		var buf bytes.Buffer
+		setAppLog(newLogger(&buf, "json"))
+		defer setAppLog(newLogger(os.Stdout, "json"))
+		write("[users]\nstore = \"memory\"\n")
+		for i := 0; i < 2; i++ {
+			if err := s.Reload(); err != nil {
+				t.Fatalf("重新载入失败：%v", err)
+			}
+		}
+		if n := strings.Count(buf.String(), "需要重启服务才能生效"); n != 1 {
+			t.Errorf("提示了 %d 次，预期 1 次：%s", n, buf.String())
+		}
+	})
+}
+
+// @brief 测试 Start 与 Shutdown 方法
+func TestStartShutdown(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	s, err := New(testConfig())
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Start(ctx) }()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("关闭服务时出错：%v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("取消 ctx 后服务没有关闭。")
+	}
+	t.Run("错误测试：监听地址错误", func(t *testing.T) {
+		s, err := New(testConfig())
+		if err != nil {
+			t.Fatalf("创建服务失败：%v", err)
+		}
+		s.endpoints[0].Address = "256.0.0.1:0"
+		if s.Start(context.Background()) == nil {
+			t.Errorf("监听地址不正确，但没有报错。")
+		}
+	})
+}
+
+// @brief 测试多个监听
+func TestMultipleListeners(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	config := testConfig()
+	config.ServerConfig = "testdata/server_listeners.toml"
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	if len(s.endpoints) != 2 {
+		t.Fatalf("监听数量不正确：%d", len(s.endpoints))
+	}
+	// 第一个监听只处理 public 组，第二个只处理 admin 组
+	cases := []struct {
+		ep     int
+		method string
+		path   string
+		code   int
+	}{
+		{0, "GET", "/", http.StatusOK},
+		{0, "POST", "/submit-data", http.StatusNotFound},
+		{1, "GET", "/", http.StatusNotFound},
+		{1, "POST", "/submit-data", http.StatusCreated},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader("test"))
+		req.Header.Set("Content-Type", "application/json")
+		addCSRF(req)
+		s.endpoints[c.ep].handler.ServeHTTP(w, req)
+		if w.Code != c.code {
+			t.Errorf("监听 %d 上 %s %s 返回 %d，预期 %d", c.ep, c.method, c.path,
+				w.Code, c.code)
+		}
+	}
+	t.Run("正常测试：unix 套接字", func(t *testing.T) {
+		s.endpoints[0].Address = filepath.Join(t.TempDir(), "sunflower.sock")
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- s.Start(ctx) }()
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn,
+				error) {
+				return net.Dial("unix", s.endpoints[0].Address)
+			}}}
+		var resp *http.Response
+		for i := 0; i < 50; i++ {
+			resp, err = client.Get("http://unix/")
+			if err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("通过 unix 套接字访问失败：%v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("通过 unix 套接字访问返回 %d", resp.StatusCode)
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("关闭服务时出错：%v", err)
+		}
+	})
+}
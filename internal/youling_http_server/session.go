@@ -0,0 +1,461 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml"
+)
+
+// @brief 会话参数
+type sessionConfig struct {
+	CookieName  string `toml:"cookie_name"`  // Cookie 名称
+	Secret      string `toml:"secret"`       // 签名密钥
+	SecretFile  string `toml:"secret_file"`  // 签名密钥文件，secret 为空时使用
+	Lifetime    int64  `toml:"lifetime"`     // 登录后的最长有效时间（秒）
+	IdleTimeout int64  `toml:"idle_timeout"` // 超过此时间（秒）没有请求时失效，0 表示不限
+	Secure      bool   `toml:"secure"`       // 是否只通过 HTTPS 发送
+	HTTPOnly    bool   `toml:"http_only"`    // 是否禁止脚本读取
+	SameSite    string `toml:"same_site"`    // lax、strict 或 none
+	Store       string `toml:"store"`        // 存储类型：memory 或 file
+	Dir         string `toml:"dir"`          // file 类型的会话目录
+}
+
+// @brief 会话
+type Session struct {
+	ID       string    `toml:"id"`        // 会话 id
+	User     string    `toml:"user"`      // 用户名
+	Created  time.Time `toml:"created"`   // 登录时间
+	LastSeen time.Time `toml:"last_seen"` // 最后一次请求的时间
+}
+
+// 会话在 gin.Context 中的键名
+const ctxSession = "session"
+
+// 找不到会话时返回的错误
+var errSessionNotFound = errors.New("会话不存在。")
+
+// 最后请求时间的更新间隔，避免每个请求都写存储
+const sessionTouchInterval = time.Minute
+
+// 删除过期会话的间隔
+const sessionPruneInterval = 10 * time.Minute
+
+// @brief 会话存储
+//  @remark 有内存与文件两种实现，由服务器参数文件中的 [session] 选择。
+type SessionStore interface {
+	// 读取会话，找不到时返回 errSessionNotFound
+	Get(id string) (Session, error)
+	// 保存会话，已存在时覆盖
+	Put(s Session) error
+	// 删除会话，不存在时不报错
+	Delete(id string) error
+	// 删除所有 expired 返回 true 的会话
+	Prune(expired func(Session) bool) error
+	// 检查存储是否可用，供就绪检查使用
+	Check() error
+}
+
+// @brief 保存在内存中的会话，重启后丢失
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+// @brief 创建内存会话存储
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: make(map[string]Session)}
+}
+
+func (m *memorySessionStore) Get(id string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return Session{}, errSessionNotFound
+	}
+	return s, nil
+}
+
+func (m *memorySessionStore) Put(s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.ID] = s
+	return nil
+}
+
+func (m *memorySessionStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memorySessionStore) Prune(expired func(Session) bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, s := range m.sessions {
+		if expired(s) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *memorySessionStore) Check() error {
+	return nil
+}
+
+// @brief 保存在文件中的会话，每个会话一个 TOML 文件，重启后仍然有效
+type fileSessionStore struct {
+	dir string
+}
+
+// @brief 会话文件的路径
+func (f *fileSessionStore) path(id string) (string, error) {
+	// 会话 id 由 newSessionID 生成，只含十六进制字符
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return "", errSessionNotFound
+	}
+	return filepath.Join(f.dir, id+".toml"), nil
+}
+
+func (f *fileSessionStore) Get(id string) (Session, error) {
+	file, err := f.path(id)
+	if err != nil {
+		return Session{}, err
+	}
+	content, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return Session{}, errSessionNotFound
+	}
+	if err != nil {
+		return Session{}, errors.New("读取会话文件失败：" + err.Error())
+	}
+	var s Session
+	if err := toml.Unmarshal(content, &s); err != nil {
+		return Session{}, errors.New("解析会话文件 " + file + " 失败：" + err.Error())
+	}
+	return s, nil
+}
+
+func (f *fileSessionStore) Put(s Session) error {
+	file, err := f.path(s.ID)
+	if err != nil {
+		return err
+	}
+	content, err := toml.Marshal(s)
+	if err != nil {
+		return errors.New("生成会话文件失败：" + err.Error())
+	}
+	return writeFileAtomic(file, content)
+}
+
+func (f *fileSessionStore) Delete(id string) error {
+	file, err := f.path(id)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.New("删除会话文件失败：" + err.Error())
+	}
+	return nil
+}
+
+func (f *fileSessionStore) Prune(expired func(Session) bool) error {
+	entries, err := os.ReadDir(f.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return errors.New("读取会话目录失败：" + err.Error())
+	}
+	for _, e := range entries {
+		id := strings.TrimSuffix(e.Name(), ".toml")
+		if e.IsDir() || id == e.Name() {
+			continue
+		}
+		s, err := f.Get(id)
+		// 无法解析的会话文件也一并删除
+		if err != nil || expired(s) {
+			f.Delete(id)
+		}
+	}
+	return nil
+}
+
+func (f *fileSessionStore) Check() error {
+	if err := os.MkdirAll(f.dir, 0700); err != nil {
+		return errors.New("无法创建会话目录 " + f.dir)
+	}
+	return nil
+}
+
+// @brief 会话管理
+//  @remark Cookie 中只保存会话 id 与签名，用户名等内容保存在会话存储中。
+type sessionManager struct {
+	conf  sessionConfig
+	store SessionStore
+	key   []byte // 签名密钥
+}
+
+// @brief 读取会话参数并创建会话管理
+//  @param file 服务器参数文件
+//  @return 会话管理，失败时返回错误信息
+func readSessions(file string) (*sessionManager, error) {
+	conf := sessionConfig{CookieName: "sunflower_session", Lifetime: 86400,
+		IdleTimeout: 1800, HTTPOnly: true, SameSite: "lax", Store: "memory"}
+	config, err := loadToml(file)
+	if err != nil {
+		return nil, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	if config.Has("session") {
+		err := config.Get("session").(*toml.Tree).Unmarshal(&conf)
+		if err != nil {
+			return nil, errors.New("解析服务器参数文件 " + file + " 中的 session 时发生错误：" +
+				err.Error())
+		}
+	}
+	if conf.CookieName == "" || conf.Lifetime <= 0 || conf.IdleTimeout < 0 {
+		return nil, errors.New("服务器参数文件 " + file + " 中 session 的参数无效。")
+	}
+	if sameSite(conf.SameSite) == 0 {
+		return nil, errors.New("未知 SameSite 类型：" + conf.SameSite)
+	}
+	m := &sessionManager{conf: conf, key: []byte(conf.Secret)}
+	switch {
+	case conf.Secret != "":
+	case conf.SecretFile != "":
+		if m.key, err = readSessionKey(conf.SecretFile); err != nil {
+			return nil, err
+		}
+	case conf.Store == "file":
+		// 随机密钥在重启或平滑重启后改变，保存在文件中的会话将全部无法验证
+		return nil, errors.New("服务器参数文件 " + file +
+			" 中 session.store 为 file 时需要设置 session.secret 或 session.secret_file。")
+	default:
+		m.key = make([]byte, 32)
+		rand.Read(m.key)
+		appLog().Info("没有设置 session.secret，使用随机密钥，重启后所有会话失效。")
+	}
+	switch conf.Store {
+	case "memory":
+		m.store = newMemorySessionStore()
+	case "file":
+		if conf.Dir == "" {
+			return nil, errors.New("服务器参数文件 " + file + " 中缺少 session.dir 字段。")
+		}
+		m.store = &fileSessionStore{dir: conf.Dir}
+	default:
+		return nil, errors.New("未知会话存储类型：" + conf.Store)
+	}
+	return m, nil
+}
+
+// @brief 读取会话签名密钥文件
+//  @param file 密钥文件
+//  @return 密钥，失败时返回错误信息
+//  @remark 文件不存在时生成随机密钥并保存，重启或平滑重启后的新进程读到同一个密钥。
+func readSessionKey(file string) ([]byte, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, errors.New("创建会话密钥目录失败：" + err.Error())
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	// 只在文件不存在时创建，避免同时启动的进程互相覆盖
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err == nil {
+		_, err = f.Write([]byte(hex.EncodeToString(key) + "\n"))
+		if e := f.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			return nil, errors.New("写入会话密钥文件 " + file + " 失败：" + err.Error())
+		}
+		appLog().Info("已生成会话签名密钥。", "file", file)
+		return key, nil
+	}
+	if !os.IsExist(err) {
+		return nil, errors.New("创建会话密钥文件 " + file + " 失败：" + err.Error())
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.New("读取会话密钥文件 " + file + " 失败：" + err.Error())
+	}
+	key, err = hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) < 16 {
+		return nil, errors.New("会话密钥文件 " + file + " 的内容无效。")
+	}
+	return key, nil
+}
+
+// @brief 转换 SameSite 参数
+//  @return SameSite 类型，参数无效时返回 0
+func sameSite(s string) http.SameSite {
+	switch strings.ToLower(s) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return 0
+}
+
+// @brief 生成新的会话 id
+func newSessionID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// @brief 计算会话 id 的签名
+func (m *sessionManager) sign(id string) string {
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// @brief 检查 Cookie 的签名
+//  @param value Cookie 的值，格式为“会话 id.签名”
+//  @return 会话 id，签名不正确时返回 false
+func (m *sessionManager) verify(value string) (string, bool) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return "", false
+	}
+	id := value[:i]
+	if !hmac.Equal([]byte(value[i+1:]), []byte(m.sign(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+// @brief 会话是否已过期
+func (m *sessionManager) expired(s Session, now time.Time) bool {
+	if now.Sub(s.Created) > time.Duration(m.conf.Lifetime)*time.Second {
+		return true
+	}
+	return m.conf.IdleTimeout > 0 &&
+		now.Sub(s.LastSeen) > time.Duration(m.conf.IdleTimeout)*time.Second
+}
+
+// @brief 设置会话 Cookie
+//  @param maxAge Cookie 的有效时间（秒），小于 0 时删除 Cookie
+func (m *sessionManager) setCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     m.conf.CookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   m.conf.Secure,
+		HttpOnly: m.conf.HTTPOnly,
+		SameSite: sameSite(m.conf.SameSite),
+	})
+}
+
+// @brief 会话中间件
+//  @remark Cookie 中的会话有效时，把会话与用户名放入上下文。
+func (m *sessionManager) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, err := c.Cookie(m.conf.CookieName)
+		if err != nil {
+			c.Next()
+			return
+		}
+		id, ok := m.verify(value)
+		if !ok {
+			c.Next()
+			return
+		}
+		s, err := m.store.Get(id)
+		if err != nil {
+			if err != errSessionNotFound {
+				appLog().Error("读取会话失败", "request_id",
+					c.GetString(ctxRequestID), "error", err)
+			}
+			c.Next()
+			return
+		}
+		now := time.Now()
+		if m.expired(s, now) {
+			m.store.Delete(id)
+			m.setCookie(c, "", -1)
+			c.Next()
+			return
+		}
+		if now.Sub(s.LastSeen) > sessionTouchInterval {
+			s.LastSeen = now
+			m.store.Put(s)
+		}
+		c.Set(ctxSession, s)
+		c.Set(ctxUser, s.User)
+		c.Next()
+	}
+}
+
+// @brief 登录后开始新的会话
+//  @param c 上下文
+//  @param user 用户名
+//  @return 成功：nil，失败：错误信息
+//  @remark 每次登录都使用新的会话 id，原来的会话一并删除，防止会话固定攻击。
+func (m *sessionManager) start(c *gin.Context, user string) error {
+	m.end(c)
+	now := time.Now()
+	s := Session{ID: newSessionID(), User: user, Created: now, LastSeen: now}
+	if err := m.store.Put(s); err != nil {
+		return err
+	}
+	m.setCookie(c, s.ID+"."+m.sign(s.ID), int(m.conf.Lifetime))
+	c.Set(ctxSession, s)
+	c.Set(ctxUser, user)
+	return nil
+}
+
+// @brief 结束当前会话
+//  @param c 上下文
+func (m *sessionManager) end(c *gin.Context) {
+	if v, ok := c.Get(ctxSession); ok {
+		m.store.Delete(v.(Session).ID)
+		m.setCookie(c, "", -1)
+	}
+}
+
+// @brief 定期删除过期的会话
+//  @param interval 检查间隔
+//  @param stop 关闭后停止检查
+func (m *sessionManager) prune(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			err := m.store.Prune(func(s Session) bool { return m.expired(s, now) })
+			if err != nil {
+				appLog().Error("删除过期会话失败", "error", err)
+			}
+		}
+	}
+}
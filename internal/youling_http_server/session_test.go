@@ -0,0 +1,192 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试会话中间件
+func TestSessions(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	m, err := readSessions("testdata/server_users.toml")
+	if err != nil {
+		t.Fatalf("读取会话参数失败：%v", err)
+	}
+	router := gin.New()
+	router.Use(m.middleware())
+	router.GET("/login", func(c *gin.Context) { m.start(c, "alice") })
+	router.GET("/logout", func(c *gin.Context) { m.end(c) })
+	router.GET("/whoami", func(c *gin.Context) {
+		c.String(200, c.GetString(ctxUser))
+	})
+	get := func(path string, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	// 1. 登录后得到签名的会话 Cookie
+	w := get("/login", "")
+	cookie := w.Header().Get("Set-Cookie")
+	if !strings.HasPrefix(cookie, "sunflower_session=") ||
+		!strings.Contains(cookie, "HttpOnly") ||
+		!strings.Contains(cookie, "SameSite=Lax") {
+		t.Fatalf("会话 Cookie 不正确：%s", cookie)
+	}
+	cookie = strings.SplitN(cookie, ";", 2)[0]
+	t.Run("带会话 Cookie 的请求", func(t *testing.T) {
+		if w := get("/whoami", cookie); w.Body.String() != "alice" {
+			t.Errorf("没有取得会话中的用户：%q", w.Body.String())
+		}
+	})
+	t.Run("错误测试：签名不正确", func(t *testing.T) {
+		forged := cookie[:len(cookie)-1] + "x"
+		if strings.HasSuffix(cookie, "x") {
+			forged = cookie[:len(cookie)-1] + "y"
+		}
+		if w := get("/whoami", forged); w.Body.String() != "" {
+			t.Errorf("签名不正确，但会话有效：%q", w.Body.String())
+		}
+	})
+	t.Run("超过空闲时间后失效", func(t *testing.T) {
+		id, _ := m.verify(strings.TrimPrefix(cookie, "sunflower_session="))
+		s, err := m.store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		saved := s
+		s.LastSeen = time.Now().Add(-2 * time.Minute)
+		m.store.Put(s)
+		if w := get("/whoami", cookie); w.Body.String() != "" {
+			t.Errorf("会话已过期，但仍然有效：%q", w.Body.String())
+		}
+		if _, err := m.store.Get(id); err != errSessionNotFound {
+			t.Errorf("过期的会话没有删除：%v", err)
+		}
+		m.store.Put(saved)
+	})
+	t.Run("退出登录", func(t *testing.T) {
+		w := get("/logout", cookie)
+		if !strings.Contains(w.Header().Get("Set-Cookie"), "Max-Age=0") {
+			t.Errorf("退出登录后没有删除 Cookie：%v", w.Header())
+		}
+		if w := get("/whoami", cookie); w.Body.String() != "" {
+			t.Errorf("退出登录后会话仍然有效：%q", w.Body.String())
+		}
+	})
+}
+
+// @brief 测试文件会话存储
+func TestFileSessionStore(t *testing.T) {
+	store := &fileSessionStore{dir: t.TempDir()}
+	if err := store.Check(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old := Session{ID: newSessionID(), User: "alice", Created: now.Add(-time.Hour),
+		LastSeen: now.Add(-time.Hour)}
+	cur := Session{ID: newSessionID(), User: "bob", Created: now, LastSeen: now}
+	for _, s := range []Session{old, cur} {
+		if err := store.Put(s); err != nil {
+			t.Fatalf("保存会话失败：%v", err)
+		}
+	}
+	if s, err := store.Get(cur.ID); err != nil || s.User != "bob" ||
+		s.Created.Unix() != cur.Created.Unix() {
+		t.Errorf("读取会话不正确：%+v %v", s, err)
+	}
+	if _, err := store.Get("../none"); err != errSessionNotFound {
+		t.Errorf("会话 id 不正确，但没有返回 errSessionNotFound：%v", err)
+	}
+	store.Prune(func(s Session) bool { return now.Sub(s.LastSeen) > time.Minute })
+	if _, err := store.Get(old.ID); err != errSessionNotFound {
+		t.Errorf("过期的会话没有删除：%v", err)
+	}
+	if _, err := store.Get(cur.ID); err != nil {
+		t.Errorf("未过期的会话被删除：%v", err)
+	}
+}
+
+// @brief 测试登录后创建会话
+func TestLoginSession(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	config := testConfig()
+	config.ServerConfig = "testdata/server_users.toml"
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	req := httptest.NewRequest("POST", "/login",
+		strings.NewReader("username=alice&password=secret"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	addCSRF(req)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther ||
+		!strings.HasPrefix(w.Header().Get("Set-Cookie"), "sunflower_session=") {
+		t.Errorf("登录后没有创建会话：%d %v", w.Code, w.Header())
+	}
+}
+
+// @brief 测试会话签名密钥
+func TestSessionKey(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		file := filepath.Join(dir, "server.toml")
+		os.WriteFile(file, []byte(content), 0600)
+		return file
+	}
+	t.Run("错误测试：file 存储没有设置密钥", func(t *testing.T) {
+		file := write("[session]\nstore = \"file\"\ndir = \"" +
+			filepath.ToSlash(filepath.Join(dir, "sessions")) + "\"\n")
+		if _, err := readSessions(file); err == nil {
+			t.Errorf("没有设置密钥，但没有报错。")
+		}
+	})
+	t.Run("密钥文件", func(t *testing.T) {
+		keyFile := filepath.ToSlash(filepath.Join(dir, "data", "session.key"))
+		file := write("[session]\nstore = \"file\"\ndir = \"" +
+			filepath.ToSlash(filepath.Join(dir, "sessions")) + "\"\nsecret_file = \"" +
+			keyFile + "\"\n")
+		m1, err := readSessions(file)
+		if err != nil {
+			t.Fatalf("读取会话参数失败：%v", err)
+		}
+		// 重启后的新进程使用同一个密钥，原来的会话仍然有效
+		m2, err := readSessions(file)
+		if err != nil {
+			t.Fatalf("读取会话参数失败：%v", err)
+		}
+		if len(m1.key) != 32 || string(m1.key) != string(m2.key) {
+			t.Errorf("两次读到的密钥不同。")
+		}
+		if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("密钥文件不正确：%v %v", info, err)
+		}
+	})
+	t.Run("错误测试：密钥文件内容无效", func(t *testing.T) {
+		keyFile := filepath.Join(dir, "bad.key")
+		os.WriteFile(keyFile, []byte("xyz"), 0600)
+		if _, err := readSessionKey(keyFile); err == nil {
+			t.Errorf("密钥文件内容无效，但没有报错。")
+		}
+	})
+}
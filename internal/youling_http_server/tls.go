@@ -0,0 +1,155 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// @brief 证书热加载器
+//  @remark 通过 GetCertificate 回调向 tls.Config 提供证书，
+//  证书文件变化或收到 SIGHUP 时重新载入，新证书解析失败时继续使用旧证书。
+type certReloader struct {
+	mu       sync.RWMutex
+	certFile string           // 证书文件
+	keyFile  string           // 私钥文件
+	cert     *tls.Certificate // 当前使用的证书
+	certMod  time.Time        // 证书文件的修改时间
+	keyMod   time.Time        // 私钥文件的修改时间
+}
+
+// @brief 创建证书热加载器
+//  @param certFile 证书文件
+//  @param keyFile 私钥文件
+//  @return 证书热加载器，失败时返回错误信息
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// @brief 重新载入证书
+//  @return 成功：nil，失败：错误信息（此时仍保留旧证书）
+func (cr *certReloader) reload() error {
+	certMod, keyMod, err := cr.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return errors.New("载入证书 " + cr.certFile + " 失败：" + err.Error())
+	}
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.certMod = certMod
+	cr.keyMod = keyMod
+	cr.mu.Unlock()
+	return nil
+}
+
+// @brief 读取证书与私钥文件的修改时间
+func (cr *certReloader) modTimes() (time.Time, time.Time, error) {
+	ci, err := os.Stat(cr.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{},
+			errors.New("读取证书文件 " + cr.certFile + " 失败：" + err.Error())
+	}
+	ki, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{},
+			errors.New("读取私钥文件 " + cr.keyFile + " 失败：" + err.Error())
+	}
+	return ci.ModTime(), ki.ModTime(), nil
+}
+
+// @brief 证书或私钥文件是否在上次载入后发生了变化
+func (cr *certReloader) changed() bool {
+	certMod, keyMod, err := cr.modTimes()
+	if err != nil {
+		// 文件暂时不可读（例如正在替换），等下一次检查
+		return false
+	}
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return !certMod.Equal(cr.certMod) || !keyMod.Equal(cr.keyMod)
+}
+
+// @brief 供 tls.Config 使用的证书回调
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate,
+	error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// @brief 定期检查证书文件，有变化时重新载入
+//  @param interval 检查间隔
+//  @param stop 关闭此通道后停止检查
+func (cr *certReloader) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if !cr.changed() {
+				continue
+			}
+			if err := cr.reload(); err != nil {
+				appLog().Error("证书文件已变化，但重新载入失败，继续使用旧证书。",
+					"error", err)
+			} else {
+				appLog().Info("证书已重新载入。", "cert_file", cr.certFile)
+			}
+		}
+	}
+}
+
+// @brief 生成使用此证书热加载器的 TLS 配置
+func (cr *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.GetCertificate,
+	}
+}
+
+// @brief 读取 TLS 参数
+//  @param file 服务器参数文件
+//  @return 证书热加载器（未启用 TLS 时为 nil）与证书检查间隔，失败时返回错误信息
+func setTLS(file string) (*certReloader, time.Duration, error) {
+	config, err := loadToml(file)
+	if err != nil {
+		return nil, 0, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	if enabled, ok := config.GetDefault("tls.enabled", false).(bool); !ok ||
+		!enabled {
+		return nil, 0, nil
+	}
+	certFile, ok := config.Get("tls.cert_file").(string)
+	if !ok {
+		return nil, 0, errors.New("服务器参数文件 " + file + " 中找不到 tls.cert_file 字段。")
+	}
+	keyFile, ok := config.Get("tls.key_file").(string)
+	if !ok {
+		return nil, 0, errors.New("服务器参数文件 " + file + " 中找不到 tls.key_file 字段。")
+	}
+	interval, _ := config.GetDefault("tls.reload_interval", int64(0)).(int64)
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	return cr, time.Duration(interval) * time.Second, nil
+}
@@ -0,0 +1,119 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// @brief 生成自签名证书并写入文件
+//  @param certFile 证书文件
+//  @param keyFile 私钥文件
+func writeTestCert(t *testing.T, certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成私钥失败：%v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey,
+		key)
+	if err != nil {
+		t.Fatalf("生成证书失败：%v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("编码私钥失败：%v", err)
+	}
+	err = os.WriteFile(certFile,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatalf("写入证书失败：%v", err)
+	}
+	err = os.WriteFile(keyFile,
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+		0600)
+	if err != nil {
+		t.Fatalf("写入私钥失败：%v", err)
+	}
+}
+
+// @brief 测试证书热加载
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile)
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("创建证书热加载器失败：%v", err)
+	}
+	old, _ := cr.GetCertificate(nil)
+	t.Run("错误测试：新证书无法解析时保留旧证书", func(t *testing.T) {
+		os.WriteFile(certFile, []byte("broken"), 0600)
+		if cr.reload() == nil {
+			t.Errorf("证书无法解析，但没有报错。")
+		}
+		cert, _ := cr.GetCertificate(nil)
+		if cert != old {
+			t.Errorf("证书解析失败后旧证书被替换了。")
+		}
+	})
+	t.Run("正常测试：文件变化后载入新证书", func(t *testing.T) {
+		writeTestCert(t, certFile, keyFile)
+		// 保证修改时间与上次载入时不同
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(certFile, future, future)
+		if !cr.changed() {
+			t.Errorf("证书文件已变化，但没有检测到。")
+		}
+		if err := cr.reload(); err != nil {
+			t.Errorf("重新载入证书失败：%v", err)
+		}
+		cert, _ := cr.GetCertificate(nil)
+		if cert == old {
+			t.Errorf("重新载入后证书没有更新。")
+		}
+		if cr.changed() {
+			t.Errorf("重新载入后仍然报告证书文件有变化。")
+		}
+	})
+}
+
+// @brief 测试 setTLS 函数
+func TestSetTLS(t *testing.T) {
+	t.Run("正常测试：未启用 TLS", func(t *testing.T) {
+		cr, _, err := setTLS("testdata/server_config.toml")
+		if err != nil || cr != nil {
+			t.Errorf("未启用 TLS 时不应设置证书：%v", err)
+		}
+	})
+	t.Run("错误测试：参数文件名错误", func(t *testing.T) {
+		_, _, err := setTLS("testdata/server_config_error.toml")
+		if err == nil {
+			t.Errorf("参数文件名不正确，但没有报错。")
+		}
+	})
+}
@@ -0,0 +1,414 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml"
+)
+
+// @brief API 令牌
+//  @remark 令牌的格式为“id.密钥”，存储中只保存密钥的 sha256，
+//  令牌只在创建时返回一次。
+type APIToken struct {
+	ID      string    `toml:"id"`      // 令牌 id
+	User    string    `toml:"user"`    // 所属用户
+	Name    string    `toml:"name"`    // 说明
+	Hash    string    `toml:"hash"`    // 密钥的 sha256（十六进制）
+	Scopes  []string  `toml:"scopes"`  // 允许的权限范围，为空时与用户的权限相同
+	Created time.Time `toml:"created"` // 创建时间
+	Expires time.Time `toml:"expires"` // 过期时间，为零值时不过期
+}
+
+// 令牌在 gin.Context 中的键名
+const ctxToken = "token"
+
+// 令牌最长的有效天数，避免计算过期时间时溢出
+const maxTokenDays = 3650
+
+// 找不到令牌时返回的错误
+var errTokenNotFound = errors.New("令牌不存在。")
+
+// @brief 令牌存储
+//  @remark 有内存与文件两种实现，由服务器参数文件中的 [tokens] 选择。
+type TokenStore interface {
+	// 读取令牌，找不到时返回 errTokenNotFound
+	Get(id string) (APIToken, error)
+	// 保存令牌，已存在时覆盖
+	Put(t APIToken) error
+	// 删除令牌，不存在时返回 errTokenNotFound
+	Delete(id string) error
+	// 列出用户的所有令牌，按创建时间排序
+	List(user string) ([]APIToken, error)
+	// 检查存储是否可用，供就绪检查使用
+	Check() error
+}
+
+// @brief 令牌文件的结构
+type tokenFile struct {
+	Tokens []APIToken `toml:"tokens"`
+}
+
+// @brief 保存在内存或 TOML 文件中的令牌
+//  @remark file 为空时只保存在内存中，重启后丢失。
+type tokenStore struct {
+	mu     sync.Mutex
+	file   string
+	tokens map[string]APIToken // 内存存储时使用
+}
+
+// @brief 载入令牌
+func (s *tokenStore) load() (map[string]APIToken, error) {
+	if s.file == "" {
+		if s.tokens == nil {
+			s.tokens = make(map[string]APIToken)
+		}
+		return s.tokens, nil
+	}
+	tokens := make(map[string]APIToken)
+	content, err := os.ReadFile(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, errors.New("读取令牌文件 " + s.file + " 失败：" + err.Error())
+	}
+	var tf tokenFile
+	if err := toml.Unmarshal(content, &tf); err != nil {
+		return nil, errors.New("解析令牌文件 " + s.file + " 失败：" + err.Error())
+	}
+	for _, t := range tf.Tokens {
+		tokens[t.ID] = t
+	}
+	return tokens, nil
+}
+
+// @brief 保存令牌
+func (s *tokenStore) save(tokens map[string]APIToken) error {
+	if s.file == "" {
+		return nil
+	}
+	var tf tokenFile
+	for _, t := range tokens {
+		tf.Tokens = append(tf.Tokens, t)
+	}
+	sort.Slice(tf.Tokens, func(i, j int) bool {
+		return tf.Tokens[i].ID < tf.Tokens[j].ID
+	})
+	content, err := toml.Marshal(tf)
+	if err != nil {
+		return errors.New("生成令牌文件失败：" + err.Error())
+	}
+	return writeFileAtomic(s.file, content)
+}
+
+func (s *tokenStore) Get(id string) (APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return APIToken{}, err
+	}
+	t, ok := tokens[id]
+	if !ok {
+		return APIToken{}, errTokenNotFound
+	}
+	return t, nil
+}
+
+func (s *tokenStore) Put(t APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return err
+	}
+	tokens[t.ID] = t
+	return s.save(tokens)
+}
+
+func (s *tokenStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := tokens[id]; !ok {
+		return errTokenNotFound
+	}
+	delete(tokens, id)
+	return s.save(tokens)
+}
+
+func (s *tokenStore) List(user string) ([]APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := []APIToken{}
+	for _, t := range tokens {
+		if t.User == user {
+			list = append(list, t)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list, nil
+}
+
+func (s *tokenStore) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.load()
+	return err
+}
+
+// @brief 读取令牌存储参数并创建令牌存储
+//  @param file 服务器参数文件
+//  @return 令牌存储，失败时返回错误信息
+//  @remark 没有 [tokens] 时使用内存存储。
+func readTokenStore(file string) (TokenStore, error) {
+	config, err := loadToml(file)
+	if err != nil {
+		return nil, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	store, _ := config.GetDefault("tokens.store", "memory").(string)
+	switch store {
+	case "memory":
+		return &tokenStore{}, nil
+	case "file":
+		f, _ := config.GetDefault("tokens.file", "").(string)
+		if f == "" {
+			return nil, errors.New("服务器参数文件 " + file + " 中缺少 tokens.file 字段。")
+		}
+		return &tokenStore{file: f}, nil
+	}
+	return nil, errors.New("未知令牌存储类型：" + store)
+}
+
+// @brief 计算令牌密钥的哈希
+func hashTokenSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// @brief 创建令牌
+//  @param store 令牌存储
+//  @param user 所属用户
+//  @param name 说明
+//  @param scopes 允许的权限范围
+//  @param ttl 有效时间，为 0 时不过期
+//  @return 令牌与令牌字符串，失败时返回错误信息
+func createToken(store TokenStore, user string, name string, scopes []string,
+	ttl time.Duration) (APIToken, string, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	rand.Read(id)
+	rand.Read(secret)
+	t := APIToken{ID: hex.EncodeToString(id), User: user, Name: name,
+		Scopes: scopes, Created: time.Now()}
+	if ttl > 0 {
+		t.Expires = t.Created.Add(ttl)
+	}
+	s := base64.RawURLEncoding.EncodeToString(secret)
+	t.Hash = hashTokenSecret(s)
+	if err := store.Put(t); err != nil {
+		return APIToken{}, "", err
+	}
+	return t, t.ID + "." + s, nil
+}
+
+// @brief 验证令牌字符串
+//  @param store 令牌存储
+//  @param token 令牌字符串
+//  @return 令牌，无效或已过期时返回错误信息
+func verifyToken(store TokenStore, token string) (APIToken, error) {
+	id, secret, ok := strings.Cut(token, ".")
+	if !ok {
+		return APIToken{}, errTokenNotFound
+	}
+	t, err := store.Get(id)
+	if err != nil {
+		return APIToken{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(t.Hash),
+		[]byte(hashTokenSecret(secret))) != 1 {
+		return APIToken{}, errors.New("令牌不正确。")
+	}
+	if !t.Expires.IsZero() && time.Now().After(t.Expires) {
+		return APIToken{}, errors.New("令牌已过期。")
+	}
+	return t, nil
+}
+
+// @brief 令牌是否允许访问路由
+//  @remark 令牌没有限制权限范围时允许访问；否则路由的 scope 必须在令牌的范围中。
+func (t APIToken) allows(r Router) bool {
+	if len(t.Scopes) == 0 {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s == r.Scope {
+			return true
+		}
+	}
+	return false
+}
+
+// @brief API 令牌中间件
+//  @param store 令牌存储
+//  @remark 请求带有 Authorization: Bearer 时验证令牌，有效时把令牌与用户名放入上下文，
+//  无效时返回 401。
+func bearerAuth(store TokenStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			c.Next()
+			return
+		}
+		t, err := verifyToken(store, strings.TrimSpace(strings.TrimPrefix(header,
+			"Bearer ")))
+		if err != nil {
+			appLog().Info("令牌验证失败", "request_id", c.GetString(ctxRequestID),
+				"client_ip", clientIP(c), "error", err)
+			c.Header("WWW-Authenticate", `Bearer realm="sunflower", error="invalid_token"`)
+			abortWithError(c, http.StatusUnauthorized)
+			return
+		}
+		c.Set(ctxToken, t)
+		c.Set(ctxUser, t.User)
+		c.Next()
+	}
+}
+
+// @brief 只允许通过会话登录的用户管理令牌，不能用令牌创建新的令牌
+//  @return 是否可以继续处理
+func sessionOnly(c *gin.Context) bool {
+	if _, ok := c.Get(ctxToken); ok || c.GetString(ctxUser) == "" {
+		abortWithError(c, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// @brief 令牌的公开信息，不包括哈希
+func tokenInfo(t APIToken) gin.H {
+	h := gin.H{"id": t.ID, "name": t.Name, "scopes": t.Scopes,
+		"created": t.Created.Format(time.RFC3339)}
+	if !t.Expires.IsZero() {
+		h["expires"] = t.Expires.Format(time.RFC3339)
+	}
+	return h
+}
+
+// @brief 列出当前用户的令牌
+//  @param store 令牌存储
+func handleListTokens(store TokenStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !sessionOnly(c) {
+			return
+		}
+		tokens, err := store.List(c.GetString(ctxUser))
+		if err != nil {
+			appLog().Error("读取令牌失败", "request_id", c.GetString(ctxRequestID),
+				"error", err)
+			abortWithError(c, http.StatusInternalServerError)
+			return
+		}
+		list := make([]gin.H, 0, len(tokens))
+		for _, t := range tokens {
+			list = append(list, tokenInfo(t))
+		}
+		c.JSON(http.StatusOK, gin.H{"tokens": list})
+	}
+}
+
+// @brief 为当前用户创建令牌
+//  @param store 令牌存储
+//  @remark 表单字段：name 说明，scope 权限范围（可以有多个），
+//  expires_in 有效天数（不填或为 0 时不过期，最多 maxTokenDays 天）。
+func handleCreateToken(store TokenStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !sessionOnly(c) {
+			return
+		}
+		days := 0
+		if v := c.PostForm("expires_in"); v != "" {
+			var err error
+			if days, err = strconv.Atoi(v); err != nil || days < 0 || days > maxTokenDays {
+				abortWithError(c, http.StatusBadRequest)
+				return
+			}
+		}
+		user := c.GetString(ctxUser)
+		t, token, err := createToken(store, user, c.PostForm("name"),
+			c.PostFormArray("scope"), time.Duration(days)*24*time.Hour)
+		if err != nil {
+			appLog().Error("创建令牌失败", "request_id", c.GetString(ctxRequestID),
+				"error", err)
+			abortWithError(c, http.StatusInternalServerError)
+			return
+		}
+		appLog().Info("创建令牌", "request_id", c.GetString(ctxRequestID),
+			"user", user, "token_id", t.ID)
+		info := tokenInfo(t)
+		info["token"] = token
+		c.JSON(http.StatusCreated, info)
+	}
+}
+
+// @brief 吊销当前用户的令牌
+//  @param store 令牌存储
+func handleRevokeToken(store TokenStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !sessionOnly(c) {
+			return
+		}
+		user := c.GetString(ctxUser)
+		id := c.Param("id")
+		t, err := store.Get(id)
+		if err == nil && t.User != user {
+			err = errTokenNotFound
+		}
+		if err == nil {
+			err = store.Delete(id)
+		}
+		if err == errTokenNotFound {
+			abortWithError(c, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			appLog().Error("吊销令牌失败", "request_id", c.GetString(ctxRequestID),
+				"error", err)
+			abortWithError(c, http.StatusInternalServerError)
+			return
+		}
+		appLog().Info("吊销令牌", "request_id", c.GetString(ctxRequestID),
+			"user", user, "token_id", id)
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,151 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试令牌的创建与验证
+func TestTokenStore(t *testing.T) {
+	stores := map[string]TokenStore{
+		"memory": &tokenStore{},
+		"file":   &tokenStore{file: filepath.Join(t.TempDir(), "tokens.toml")},
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			tk, token, err := createToken(store, "alice", "测试", []string{"tasks"},
+				time.Hour)
+			if err != nil {
+				t.Fatalf("创建令牌失败：%v", err)
+			}
+			if strings.Contains(tk.Hash, strings.SplitN(token, ".", 2)[1]) {
+				t.Errorf("存储中保存了令牌的明文。")
+			}
+			if v, err := verifyToken(store, token); err != nil || v.User != "alice" ||
+				len(v.Scopes) != 1 {
+				t.Errorf("验证令牌失败：%+v %v", v, err)
+			}
+			if _, err := verifyToken(store, tk.ID+".wrong"); err == nil {
+				t.Errorf("令牌不正确，但验证通过。")
+			}
+			list, err := store.List("alice")
+			if err != nil || len(list) != 1 || list[0].ID != tk.ID {
+				t.Errorf("列出令牌不正确：%+v %v", list, err)
+			}
+			if err := store.Delete(tk.ID); err != nil {
+				t.Errorf("删除令牌失败：%v", err)
+			}
+			if _, err := verifyToken(store, token); err != errTokenNotFound {
+				t.Errorf("令牌已删除，但验证通过：%v", err)
+			}
+		})
+	}
+	t.Run("错误测试：令牌已过期", func(t *testing.T) {
+		store := &tokenStore{}
+		tk, token, _ := createToken(store, "alice", "", nil, time.Hour)
+		tk.Expires = time.Now().Add(-time.Minute)
+		store.Put(tk)
+		if _, err := verifyToken(store, token); err == nil {
+			t.Errorf("令牌已过期，但验证通过。")
+		}
+	})
+}
+
+// @brief 测试通过 http 管理与使用令牌
+func TestTokenRoutes(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	config := testConfig()
+	config.ServerConfig = "testdata/server_users.toml"
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	cookie := loginCookie(t, s, "bob")
+	do := func(method string, path string, body url.Values,
+		header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		addCSRF(req)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w
+	}
+	create := func(scopes ...string) (string, string) {
+		w := do("POST", "/tokens", url.Values{"name": {"脚本"}, "scope": scopes},
+			map[string]string{"Cookie": cookie})
+		var resp struct{ ID, Token string }
+		if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+			t.Fatalf("创建令牌失败：%d %s", w.Code, w.Body.String())
+		}
+		return resp.ID, resp.Token
+	}
+	id, token := create()
+	_, scoped := create("tasks")
+	bearer := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+	t.Run("使用令牌访问", func(t *testing.T) {
+		if w := do("GET", "/private", nil, bearer(token)); w.Code != http.StatusOK {
+			t.Errorf("使用令牌访问返回 %d", w.Code)
+		}
+	})
+	t.Run("令牌的权限范围不包括此路由", func(t *testing.T) {
+		if w := do("GET", "/private", nil, bearer(scoped)); w.Code != http.StatusForbidden {
+			t.Errorf("返回 %d，预期 403", w.Code)
+		}
+	})
+	t.Run("不能用令牌管理令牌", func(t *testing.T) {
+		if w := do("GET", "/tokens", nil, bearer(token)); w.Code != http.StatusForbidden {
+			t.Errorf("返回 %d，预期 403", w.Code)
+		}
+	})
+	t.Run("错误测试：有效天数无效", func(t *testing.T) {
+		for _, v := range []string{"-1", "abc", "3651", "106751992"} {
+			w := do("POST", "/tokens", url.Values{"name": {"脚本"}, "expires_in": {v}},
+				map[string]string{"Cookie": cookie})
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expires_in=%s：返回 %d，预期 400", v, w.Code)
+			}
+		}
+	})
+	t.Run("列出令牌", func(t *testing.T) {
+		w := do("GET", "/tokens", nil, map[string]string{"Cookie": cookie})
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), id) ||
+			strings.Contains(w.Body.String(), "hash") {
+			t.Errorf("列出令牌不正确：%d %s", w.Code, w.Body.String())
+		}
+	})
+	t.Run("吊销令牌", func(t *testing.T) {
+		w := do("DELETE", "/tokens/"+id, nil, map[string]string{"Cookie": cookie})
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("吊销令牌返回 %d", w.Code)
+		}
+		if w := do("GET", "/private", nil, bearer(token)); w.Code != http.StatusUnauthorized {
+			t.Errorf("令牌已吊销，但返回 %d", w.Code)
+		}
+		w = do("DELETE", "/tokens/"+id, nil, map[string]string{"Cookie": cookie})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("令牌不存在，但返回 %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,241 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pelletier/go-toml"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// @brief 用户
+type User struct {
+	Name         string   `toml:"name"`          // 用户名
+	PasswordHash string   `toml:"password_hash"` // bcrypt 密码哈希
+	Roles        []string `toml:"roles"`         // 角色列表
+}
+
+// 找不到用户时返回的错误
+var errUserNotFound = errors.New("用户不存在。")
+
+// @brief 用户存储
+//  @remark 有内存与文件两种实现，由服务器参数文件中的 [users] 选择。
+type UserStore interface {
+	// 读取用户，找不到时返回 errUserNotFound
+	Get(name string) (User, error)
+	// 保存用户，已存在时覆盖
+	Put(u User) error
+	// 检查存储是否可用，供就绪检查使用
+	Check() error
+}
+
+// @brief 保存在内存中的用户，重启后丢失，用于测试与开发
+type memoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]User
+}
+
+// @brief 创建内存用户存储
+func newMemoryUserStore() *memoryUserStore {
+	return &memoryUserStore{users: make(map[string]User)}
+}
+
+func (m *memoryUserStore) Get(name string) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.users[name]
+	if !ok {
+		return User{}, errUserNotFound
+	}
+	return u, nil
+}
+
+func (m *memoryUserStore) Put(u User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[u.Name] = u
+	return nil
+}
+
+func (m *memoryUserStore) Check() error {
+	return nil
+}
+
+// @brief 保存在 TOML 文件中的用户
+//  @remark 每次读取时都重新载入文件，手工修改文件后不需要重启服务。
+//  文件不存在时视为没有用户。
+type fileUserStore struct {
+	mu   sync.Mutex
+	file string
+}
+
+// @brief 用户文件的结构
+type userFile struct {
+	Users []User `toml:"users"`
+}
+
+// @brief 载入用户文件
+func (f *fileUserStore) load() (map[string]User, error) {
+	users := make(map[string]User)
+	content, err := os.ReadFile(f.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return users, nil
+	}
+	if err != nil {
+		return nil, errors.New("读取用户文件 " + f.file + " 失败：" + err.Error())
+	}
+	var uf userFile
+	if err := toml.Unmarshal(content, &uf); err != nil {
+		return nil, errors.New("解析用户文件 " + f.file + " 失败：" + err.Error())
+	}
+	for _, u := range uf.Users {
+		users[u.Name] = u
+	}
+	return users, nil
+}
+
+func (f *fileUserStore) Get(name string) (User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users, err := f.load()
+	if err != nil {
+		return User{}, err
+	}
+	u, ok := users[name]
+	if !ok {
+		return User{}, errUserNotFound
+	}
+	return u, nil
+}
+
+func (f *fileUserStore) Put(u User) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	users, err := f.load()
+	if err != nil {
+		return err
+	}
+	users[u.Name] = u
+	var uf userFile
+	for _, u := range users {
+		uf.Users = append(uf.Users, u)
+	}
+	sort.Slice(uf.Users, func(i, j int) bool {
+		return uf.Users[i].Name < uf.Users[j].Name
+	})
+	content, err := toml.Marshal(uf)
+	if err != nil {
+		return errors.New("生成用户文件失败：" + err.Error())
+	}
+	return writeFileAtomic(f.file, content)
+}
+
+func (f *fileUserStore) Check() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.load()
+	return err
+}
+
+// @brief 写入文件，先写临时文件再改名，避免写到一半时被读取
+//  @param file 文件
+//  @param content 文件内容
+func writeFileAtomic(file string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return errors.New("创建目录失败：" + err.Error())
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return errors.New("创建临时文件失败：" + err.Error())
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return errors.New("写入 " + file + " 失败：" + err.Error())
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.New("写入 " + file + " 失败：" + err.Error())
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return errors.New("写入 " + file + " 失败：" + err.Error())
+	}
+	return nil
+}
+
+// @brief 读取用户存储参数并创建用户存储
+//  @param file 服务器参数文件
+//  @return 用户存储，失败时返回错误信息
+//  @remark 没有 [users] 时使用内存存储。
+func readUserStore(file string) (UserStore, error) {
+	config, err := loadToml(file)
+	if err != nil {
+		return nil, errors.New("载入服务器参数文件 " + file + " 时发生错误。")
+	}
+	store, _ := config.GetDefault("users.store", "memory").(string)
+	switch store {
+	case "memory":
+		return newMemoryUserStore(), nil
+	case "file":
+		f, _ := config.GetDefault("users.file", "").(string)
+		if f == "" {
+			return nil, errors.New("服务器参数文件 " + file + " 中缺少 users.file 字段。")
+		}
+		return &fileUserStore{file: f}, nil
+	}
+	return nil, errors.New("未知用户存储类型：" + store)
+}
+
+// @brief 计算密码哈希
+//  @param password 密码
+//  @return bcrypt 密码哈希，失败时返回错误信息
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.New("计算密码哈希失败：" + err.Error())
+	}
+	return string(hash), nil
+}
+
+// 用户不存在时用来比较的哈希，使用户是否存在不会从响应时间上看出来
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// @brief 取得用户不存在时用来比较的哈希
+//  @remark 第一次使用时才计算，避免每次启动程序都多做一次 bcrypt。
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("sunflower"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+// @brief 验证用户名与密码
+//  @param store 用户存储
+//  @param name 用户名
+//  @param password 密码
+//  @return 用户，用户名或密码错误时返回错误信息
+func authenticate(store UserStore, name string, password string) (User, error) {
+	u, err := store.Get(name)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return User{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash),
+		[]byte(password)) != nil {
+		return User{}, errors.New("密码错误。")
+	}
+	return u, nil
+}
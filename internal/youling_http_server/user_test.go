@@ -0,0 +1,141 @@
+// @copyright Copyright 2024 Willard Lu
+// @email willard.lu@outlook.com
+// @language go 1.18.1
+// @author 陆巍
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+package youling_http_server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @brief 测试用户存储
+func TestUserStore(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]UserStore{
+		"memory": newMemoryUserStore(),
+		"file":   &fileUserStore{file: filepath.Join(t.TempDir(), "users.toml")},
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.Get("bob"); err != errUserNotFound {
+				t.Errorf("用户不存在，但没有返回 errUserNotFound：%v", err)
+			}
+			if err := store.Put(User{Name: "bob", PasswordHash: hash,
+				Roles: []string{"editor"}}); err != nil {
+				t.Fatalf("保存用户失败：%v", err)
+			}
+			u, err := store.Get("bob")
+			if err != nil || u.Name != "bob" || len(u.Roles) != 1 {
+				t.Errorf("读取用户不正确：%+v %v", u, err)
+			}
+			if _, err := authenticate(store, "bob", "secret"); err != nil {
+				t.Errorf("密码正确，但验证失败：%v", err)
+			}
+			if _, err := authenticate(store, "bob", "wrong"); err == nil {
+				t.Errorf("密码错误，但验证通过。")
+			}
+			if err := store.Check(); err != nil {
+				t.Errorf("检查用户存储失败：%v", err)
+			}
+		})
+	}
+	t.Run("读取用户存储参数", func(t *testing.T) {
+		if _, err := readUserStore("testdata/server_users.toml"); err != nil {
+			t.Errorf("读取用户存储参数失败：%v", err)
+		}
+		if _, err := readUserStore("testdata/none.toml"); err == nil {
+			t.Errorf("服务器参数文件不存在，但没有报错。")
+		}
+	})
+}
+
+// @brief 测试登录后的跳转地址
+func TestLocalRedirect(t *testing.T) {
+	cases := []struct {
+		next string
+		want string
+	}{
+		{"/task-list", "/task-list"},
+		{"/task-list?id=1#top", "/task-list?id=1#top"},
+		{"", "/"},
+		{"task-list", "/"},
+		{"https://example.com/", "/"},
+		{"//example.com/", "/"},
+		{"/\\example.com/", "/"},
+		{"/\t/example.com", "/"},
+		{"/\n/example.com", "/"},
+		{"/\r/example.com", "/"},
+		{"/ /example.com", "/"},
+		{"/\x00/example.com", "/"},
+		{"/\u3000/example.com", "/"},
+	}
+	for _, c := range cases {
+		if got := localRedirect(c.next); got != c.want {
+			t.Errorf("%q 返回 %q，预期 %q", c.next, got, c.want)
+		}
+	}
+}
+
+// @brief 测试登录与退出登录
+func TestLogin(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	config := testConfig()
+	config.ServerConfig = "testdata/server_users.toml"
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("创建服务失败：%v", err)
+	}
+	login := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/login",
+			strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		addCSRF(req)
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w
+	}
+	t.Run("登录成功", func(t *testing.T) {
+		w := login(url.Values{"username": {"alice"}, "password": {"secret"},
+			"next": {"/task-list"}})
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/task-list" {
+			t.Errorf("登录成功后没有跳转：%d %v", w.Code, w.Header())
+		}
+	})
+	t.Run("登录失败", func(t *testing.T) {
+		w := login(url.Values{"username": {"alice"}, "password": {"wrong"}})
+		if w.Code != http.StatusUnauthorized ||
+			!strings.Contains(w.Body.String(), "用户名或密码错误") {
+			t.Errorf("登录失败的响应不正确：%d %s", w.Code, w.Body.String())
+		}
+	})
+	t.Run("不跳转到其它网站", func(t *testing.T) {
+		w := login(url.Values{"username": {"alice"}, "password": {"secret"},
+			"next": {"//example.com/"}})
+		if w.Header().Get("Location") != "/" {
+			t.Errorf("跳转地址不正确：%s", w.Header().Get("Location"))
+		}
+	})
+	t.Run("退出登录", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/logout", nil)
+		addCSRF(req)
+		s.Handler().ServeHTTP(w, req)
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+			t.Errorf("退出登录后没有跳转：%d %v", w.Code, w.Header())
+		}
+	})
+}
@@ -16,11 +16,26 @@ function closeDialog() {
   document.querySelector("#myDialog").close();
 }
 
+// 登录按钮跳转到登录页面。不使用 onclick 属性，CSP 禁止内联脚本时仍然有效
+document.addEventListener("DOMContentLoaded", () => {
+  const login = document.getElementById("login-button");
+  if (login) {
+    login.addEventListener("click", () => {
+      location.href = login.dataset.href;
+    });
+  }
+});
+
 // 提交数据
 function submitData() {
   const xhr = new XMLHttpRequest();
   xhr.open("POST", "/submit-data");
-  xhr.setRequestHeader("Content-Type", "application/x-www-form-urlencoded");
+  xhr.setRequestHeader("Content-Type", "application/json");
+  // CSRF 令牌由服务器填入页面的 meta 标签中
+  const csrf = document.querySelector('meta[name="csrf-token"]');
+  if (csrf) {
+    xhr.setRequestHeader("X-CSRF-Token", csrf.content);
+  }
   const body = JSON.stringify({
     "task_number": document.getElementById("task-number").value,
     "task_name": document.getElementById("task-name").value
@@ -33,7 +48,8 @@ function submitData() {
       alert(data);
       console.log(data);
     } else {
-      console.log("Error: ${xhr.status}");
+      // 带上请求编号，便于和服务器日志对应
+      console.log(`Error: ${xhr.status}, request id: ${xhr.getResponseHeader("X-Request-ID")}`);
     }
   }
   xhr.send(body);